@@ -1,20 +1,29 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"os"
 
 	"github.com/dbadylan/go-mysql-archiver/internal/biz"
 	"github.com/dbadylan/go-mysql-archiver/internal/config"
+	"github.com/dbadylan/go-mysql-archiver/internal/logger"
 )
 
 func main() {
 	cfg, err := config.NewFlag()
 	if err != nil {
-		fmt.Println(err)
-		return
+		logger.Error(err.Error())
+		os.Exit(biz.ExitFailure)
 	}
-	if err = biz.Run(cfg); err != nil {
-		fmt.Println(err)
-		return
+	l, err := logger.New(cfg.Log)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(biz.ExitFailure)
+	}
+	logger.SetDefault(l)
+	if err = biz.Run(context.Background(), cfg); err != nil {
+		logger.Error(err.Error())
 	}
+	_ = l.Close()
+	os.Exit(biz.ExitCode(err))
 }
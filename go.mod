@@ -2,4 +2,10 @@ module github.com/dbadylan/go-mysql-archiver
 
 go 1.17
 
-require github.com/go-sql-driver/mysql v1.7.1
+require (
+	github.com/BurntSushi/toml v1.3.2
+	github.com/go-sql-driver/mysql v1.7.1
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require github.com/klauspost/compress v1.15.15
@@ -0,0 +1,441 @@
+package biz
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dbadylan/go-mysql-archiver/internal/config"
+	"github.com/dbadylan/go-mysql-archiver/internal/data"
+	"github.com/dbadylan/go-mysql-archiver/internal/logger"
+)
+
+// task
+//  what a batch needs to know about the running task
+type task struct {
+	cfg      *config.Config
+	analysis data.Analysis
+	source   data.RowSource
+	// sink is nil when the rows are only deleted
+	sink data.RowSink
+	// ownsSink is true while the file sink opened for the table is to be closed by the task
+	ownsSink     bool
+	xaGen        *xaGenerator
+	checkpointer *checkpointer
+	cursor       [][]byte
+	reconciler   *reconciler
+	control      *control
+	progress     *progress
+	metrics      *metrics
+	hooks        Hooks
+}
+
+type batchResult struct {
+	// limit is the batch size the batch was fetched with, it may be changed between batches
+	limit   int64
+	rows    int64
+	inserts int64
+	deletes int64
+	// stopped is true when the task was stopped while the batch was waiting to be retried
+	stopped bool
+}
+
+// runBatch
+//  archive the next batch. When retryable is true, the error is transient and both transactions have been
+//  rolled back before anything was committed, so the batch can simply be run again
+func (t *task) runBatch() (result batchResult, retryable bool, err error) {
+	cfg := t.cfg
+	limit := t.control.limit()
+	result.limit = limit
+	var selectTook, insertTook, deleteTook, commitTook time.Duration
+	begin := time.Now()
+	selectCtx, cancelSelect := t.control.statement()
+	batch, e := t.source.Fetch(selectCtx, t.cursor, limit)
+	cancelSelect()
+	selectTook = time.Since(begin)
+	t.metrics.observe(phaseSelect, selectTook)
+	if e != nil {
+		err = e
+		retryable = data.IsTransient(err)
+		return
+	}
+	if batch.Rows == 0 {
+		return
+	}
+
+	var (
+		srcTx data.SourceTx
+		tgtTx data.SinkTx
+		srcXA data.XASourceTx
+		tgtXA data.XASinkTx
+		// committed is true once nothing is left to be rolled back, tgtCommitted once the sink has committed
+		committed, tgtCommitted bool
+	)
+	defer func() {
+		if err == nil || committed {
+			return
+		}
+		if srcTx != nil {
+			_ = srcTx.Rollback()
+		}
+		if tgtTx != nil && !tgtCommitted {
+			_ = tgtTx.Rollback()
+		}
+	}()
+	beginCtx, cancelBegin := t.control.statement()
+	defer cancelBegin()
+	if cfg.XA {
+		xaSource, ok1 := t.source.(data.XASource)
+		xaSink, ok2 := t.sink.(data.XASink)
+		if !ok1 || !ok2 {
+			err = errors.New("xa: the source and the sink must both support XA transactions")
+			return
+		}
+		gtrid := t.xaGen.next()
+		if srcXA, err = xaSource.BeginXA(beginCtx, gtrid); err != nil {
+			retryable = data.IsTransient(err)
+			return
+		}
+		srcTx = srcXA
+		if tgtXA, err = xaSink.BeginXA(beginCtx, gtrid); err != nil {
+			retryable = data.IsTransient(err)
+			return
+		}
+		tgtTx = tgtXA
+	} else {
+		if !cfg.NoDelete {
+			if srcTx, err = t.source.Begin(beginCtx); err != nil {
+				retryable = data.IsTransient(err)
+				return
+			}
+		}
+		if t.sink != nil {
+			if tgtTx, err = t.sink.Begin(beginCtx); err != nil {
+				retryable = data.IsTransient(err)
+				return
+			}
+		}
+	}
+
+	var checksum data.Checksum
+	if t.reconciler != nil {
+		// taken before the rows are deleted, it is what the target is reconciled against after the run
+		checksummer, ok := srcTx.(data.Checksummer)
+		if !ok {
+			checksummer, ok = t.source.(data.Checksummer)
+		}
+		if !ok {
+			err = errors.New("reconcile: the source can not checksum the rows")
+			return
+		}
+		ctx, cancel := t.control.statement()
+		defer cancel()
+		if checksum, err = checksummer.Checksum(ctx, batch); err != nil {
+			retryable = data.IsTransient(err)
+			return
+		}
+	}
+
+	var (
+		wg                   = new(sync.WaitGroup)
+		inserts, deletes     int64
+		insertErr, deleteErr error
+	)
+	begin = time.Now()
+	if tgtTx != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := t.control.statement()
+			defer cancel()
+			begin := time.Now()
+			inserts, insertErr = tgtTx.Write(ctx, batch)
+			insertTook = time.Since(begin)
+			t.metrics.observe(phaseInsert, insertTook)
+		}()
+	}
+	if srcTx != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := t.control.statement()
+			defer cancel()
+			begin := time.Now()
+			deletes, deleteErr = srcTx.Delete(ctx, batch)
+			deleteTook = time.Since(begin)
+			t.metrics.observe(phaseDelete, deleteTook)
+		}()
+	}
+	wg.Wait()
+	t.metrics.add(actionWrite, time.Since(begin))
+
+	var errs []string
+	retryable = true
+	for _, e := range []error{insertErr, deleteErr} {
+		if e != nil {
+			errs = append(errs, e.Error())
+			retryable = retryable && data.IsTransient(e)
+		}
+	}
+	if len(errs) != 0 {
+		err = errors.New(strings.Join(errs, "\n"))
+		return
+	}
+	retryable = false
+
+	if cfg.Verify {
+		verifier, ok := tgtTx.(data.Verifier)
+		if !ok {
+			err = errors.New("verify: the sink can not read the rows back")
+			return
+		}
+		ctx, cancel := t.control.statement()
+		defer cancel()
+		if err = verifier.Verify(ctx, batch); err != nil {
+			retryable = data.IsTransient(err)
+			return
+		}
+	}
+
+	if !cfg.Purge && inserts < deletes {
+		err = fmt.Errorf("rows deleted(%d) larger than inserted(%d), rollback and exit", deletes, inserts)
+		return
+	}
+
+	inFlight := &data.Batch{
+		Cursor: batch.Cursor,
+		Where:  batch.Key.Where,
+		Values: batch.Key.Values,
+		Rows:   deletes,
+	}
+	// only the commits are timed, not the checkpoint saves between them
+	commit := func(action string, fn func() error) error {
+		begin := time.Now()
+		defer func() { commitTook += time.Since(begin) }()
+		return t.metrics.time(action, fn)
+	}
+	switch {
+	case cfg.XA:
+		// commitXA rolls back the branches itself, or leaves them prepared for recoverXA
+		committed = true
+		begin = time.Now()
+		err = commitXA(srcXA, tgtXA, t.metrics)
+		commitTook = time.Since(begin)
+		if err != nil {
+			return
+		}
+	case cfg.NoDelete:
+		// the cursor is all that has to be recorded, nothing is left half-committed on the source
+		if tgtTx != nil {
+			if err = t.checkpointer.save(data.PhasePrepared, t.cursor, inFlight); err != nil {
+				return
+			}
+			if err = commit(actionCommitTarget, tgtTx.Commit); err != nil {
+				return
+			}
+		}
+	case cfg.Purge:
+		if err = commit(actionCommitSource, srcTx.Commit); err != nil {
+			return
+		}
+	default:
+		// the rows must be committed by the sink, which is when a file sink writes them to disk, before the
+		// source is allowed to commit
+		if err = t.checkpointer.save(data.PhasePrepared, t.cursor, inFlight); err != nil {
+			return
+		}
+		if err = commit(actionCommitTarget, tgtTx.Commit); err != nil {
+			return
+		}
+		tgtCommitted = true
+		if err = t.checkpointer.save(data.PhaseTargetCommitted, t.cursor, inFlight); err != nil {
+			return
+		}
+		if err = commit(actionCommitSource, srcTx.Commit); err != nil {
+			return
+		}
+	}
+	committed = true
+	t.metrics.observe(phaseCommit, commitTook)
+	from := t.cursor
+	t.cursor = batch.Cursor
+	if err = t.checkpointer.save(data.PhaseCommitted, t.cursor, nil); err != nil {
+		return
+	}
+
+	if t.reconciler != nil {
+		ctx, cancel := t.control.statement()
+		t.reconciler.add(ctx, batch.Columns, batch.Key.Values, batch.Cursor, checksum)
+		cancel()
+	}
+
+	result = batchResult{limit: limit, rows: batch.Rows, inserts: inserts, deletes: deletes}
+	if logger.Default().Enabled(logger.LevelDebug) {
+		fields := []interface{}{"batch", t.progress.snapshot().Batches + 1}
+		if len(batch.Cursor) != 0 {
+			// the range is exclusive of from, which is empty for the first batch
+			fields = append(fields, "from", formatKey(from), "to", formatKey(batch.Cursor))
+		}
+		fields = append(fields, "rows", batch.Rows, "inserted", inserts, "deleted", deletes,
+			"select", selectTook, "insert", insertTook, "delete", deleteTook, "commit", commitTook)
+		logger.Debug("batch", fields...)
+	}
+	return
+}
+
+// runBatchWithRetries
+//  run the next batch again after an exponential backoff as long as it fails with transient errors
+func (t *task) runBatchWithRetries() (result batchResult, err error) {
+	var retryable bool
+	for attempt := 0; ; attempt++ {
+		if result, retryable, err = t.runBatch(); err == nil {
+			return
+		}
+		t.progress.fail()
+		if t.hooks.OnError != nil {
+			t.hooks.OnError(err)
+		}
+		if !retryable || attempt >= t.cfg.Retries || t.control.aborted() {
+			return
+		}
+		t.progress.retry()
+		wait := t.cfg.RetryInterval << attempt
+		if wait > maxRetryInterval || wait <= 0 {
+			wait = maxRetryInterval
+		}
+		logger.Warn("retry", "attempt", attempt+1, "retries", t.cfg.Retries, "wait", wait, "error", err)
+		if !t.control.wait(wait) {
+			// nothing of the batch has been committed, it is left to the next run
+			result, err = batchResult{stopped: true}, nil
+			return
+		}
+	}
+}
+
+const maxRetryInterval = time.Minute
+
+// closeSink
+//  close the file sink of the table, once, the sink given to the job is closed by the job
+func (t *task) closeSink() (err error) {
+	if !t.ownsSink {
+		return
+	}
+	t.ownsSink = false
+	err = t.sink.Close()
+	return
+}
+
+// run
+//  archive the table batch by batch until it is done, or the task is stopped
+func (t *task) run(throttle *throttler) (err error) {
+	var done bool
+	t.progress.setState(tableRunning, nil)
+	defer func() {
+		if e := t.closeSink(); e != nil && err == nil {
+			err = e
+		}
+		switch {
+		case err != nil:
+			t.progress.setState(tableFailed, err)
+		case done:
+			t.progress.setState(tableDone, nil)
+		default:
+			t.progress.setState(tableStopped, nil)
+		}
+	}()
+
+	var (
+		sleep         = new(time.Ticker)
+		sleepInterval time.Duration
+	)
+	defer func() {
+		if sleepInterval > 0 {
+			sleep.Stop()
+		}
+	}()
+	for {
+		if _, stopped := t.control.state(); stopped {
+			return
+		}
+		begin := time.Now()
+		result, e1 := t.runBatchWithRetries()
+		if e1 != nil {
+			if t.control.aborted() {
+				// the commits are not bound to the context, so the batch has either been committed as a whole or
+				// failed before committing and been rolled back
+				logger.Warn("the batch in flight has been cancelled and rolled back", "table", t.cfg.Source.Table, "error", e1)
+				return
+			}
+			err = e1
+			return
+		}
+		if result.stopped {
+			return
+		}
+		if result.rows == 0 {
+			done = true
+			return
+		}
+		t.progress.add(result, t.cursor)
+		if t.hooks.OnBatch != nil {
+			batch := Batch{
+				Table:    t.cfg.Source.Table,
+				Number:   t.progress.snapshot().Batches,
+				Rows:     result.rows,
+				Inserted: result.inserts,
+				Deleted:  result.deletes,
+				Took:     time.Since(begin),
+			}
+			if len(t.cursor) != 0 {
+				batch.Cursor = formatKey(t.cursor)
+			}
+			t.hooks.OnBatch(batch)
+		}
+
+		if result.rows < result.limit {
+			done = true
+			return
+		}
+
+		if throttle.enabled() {
+			begin := time.Now()
+			err = throttle.wait()
+			t.metrics.add(actionThrottle, time.Since(begin))
+			if err != nil {
+				if !t.control.aborted() {
+					return
+				}
+				err = nil
+			}
+		}
+
+		if paused, _ := t.control.state(); paused {
+			begin := time.Now()
+			t.control.waitPaused()
+			t.metrics.add(actionPause, time.Since(begin))
+			continue
+		}
+
+		// the ticker is replaced once the interval is changed by the socket
+		if interval := t.control.sleep(); interval != sleepInterval {
+			if sleepInterval > 0 {
+				sleep.Stop()
+			}
+			sleep, sleepInterval = new(time.Ticker), interval
+			if interval > 0 {
+				sleep = time.NewTicker(interval)
+			}
+		}
+		if sleepInterval != 0 {
+			begin := time.Now()
+			select {
+			case <-sleep.C:
+			case <-t.control.done:
+			}
+			t.metrics.add(actionSleep, time.Since(begin))
+			continue
+		}
+	}
+}
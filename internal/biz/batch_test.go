@@ -0,0 +1,225 @@
+package biz
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/dbadylan/go-mysql-archiver/internal/config"
+	"github.com/dbadylan/go-mysql-archiver/internal/data"
+)
+
+// events
+//  what the fake transactions have been asked to do, in order
+type events struct {
+	mu   sync.Mutex
+	list []string
+}
+
+func (e *events) add(event string) {
+	e.mu.Lock()
+	e.list = append(e.list, event)
+	e.mu.Unlock()
+}
+
+func (e *events) has(event string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, v := range e.list {
+		if v == event {
+			return true
+		}
+	}
+	return false
+}
+
+// ordered
+//  the commits and rollbacks only, the write and the delete run concurrently
+func (e *events) ordered() (list []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, v := range e.list {
+		if v != "sink write" && v != "source delete" {
+			list = append(list, v)
+		}
+	}
+	return
+}
+
+type fakeSource struct {
+	events    *events
+	deleteErr error
+	commitErr error
+}
+
+func (s *fakeSource) Fetch(ctx context.Context, cursor [][]byte, limit int64) (*data.RowBatch, error) {
+	return &data.RowBatch{
+		Table:   "t",
+		Columns: []string{"id"},
+		Types:   []string{"INT"},
+		Values:  [][]byte{[]byte("1"), []byte("2")},
+		Rows:    2,
+		Cursor:  [][]byte{[]byte("2")},
+	}, nil
+}
+
+func (s *fakeSource) Begin(ctx context.Context) (data.SourceTx, error) {
+	return &fakeSourceTx{source: s}, nil
+}
+
+type fakeSourceTx struct {
+	source *fakeSource
+}
+
+func (t *fakeSourceTx) Delete(ctx context.Context, batch *data.RowBatch) (int64, error) {
+	t.source.events.add("source delete")
+	if t.source.deleteErr != nil {
+		return 0, t.source.deleteErr
+	}
+	return batch.Rows, nil
+}
+
+func (t *fakeSourceTx) Commit() error {
+	t.source.events.add("source commit")
+	return t.source.commitErr
+}
+
+func (t *fakeSourceTx) Rollback() error {
+	t.source.events.add("source rollback")
+	return nil
+}
+
+type fakeSink struct {
+	events    *events
+	writeErr  error
+	commitErr error
+}
+
+func (s *fakeSink) Begin(ctx context.Context) (data.SinkTx, error) {
+	return &fakeSinkTx{sink: s}, nil
+}
+
+func (s *fakeSink) Close() error {
+	return nil
+}
+
+type fakeSinkTx struct {
+	sink *fakeSink
+}
+
+func (t *fakeSinkTx) Write(ctx context.Context, batch *data.RowBatch) (int64, error) {
+	t.sink.events.add("sink write")
+	if t.sink.writeErr != nil {
+		return 0, t.sink.writeErr
+	}
+	return batch.Rows, nil
+}
+
+func (t *fakeSinkTx) Commit() error {
+	t.sink.events.add("sink commit")
+	return t.sink.commitErr
+}
+
+func (t *fakeSinkTx) Rollback() error {
+	t.sink.events.add("sink rollback")
+	return nil
+}
+
+func newFakeTask(source *fakeSource, sink *fakeSink) *task {
+	cfg := new(config.Config)
+	cfg.Source.Limit = 2
+	return &task{
+		cfg:          cfg,
+		source:       source,
+		sink:         sink,
+		checkpointer: newCheckpointer(cfg, data.Analysis{}),
+		control:      newControl(cfg),
+		progress:     new(progress),
+		metrics:      newMetrics(),
+	}
+}
+
+func TestRunBatchCommitsSinkBeforeSource(t *testing.T) {
+	e := new(events)
+	task := newFakeTask(&fakeSource{events: e}, &fakeSink{events: e})
+	result, _, err := task.runBatch()
+	if err != nil {
+		t.Fatalf("runBatch: %v", err)
+	}
+	if result.inserts != 2 || result.deletes != 2 {
+		t.Errorf("inserted %d and deleted %d rows, want 2 and 2", result.inserts, result.deletes)
+	}
+	if want := []string{"sink commit", "source commit"}; !reflect.DeepEqual(e.ordered(), want) {
+		t.Errorf("got %v, want %v", e.ordered(), want)
+	}
+}
+
+func TestRunBatchRollsBackBothOnFailure(t *testing.T) {
+	failure := errors.New("failure")
+	for _, c := range []struct {
+		name      string
+		writeErr  error
+		deleteErr error
+	}{
+		{name: "write", writeErr: failure},
+		{name: "delete", deleteErr: failure},
+		{name: "both", writeErr: failure, deleteErr: failure},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			e := new(events)
+			task := newFakeTask(&fakeSource{events: e, deleteErr: c.deleteErr}, &fakeSink{events: e, writeErr: c.writeErr})
+			if _, _, err := task.runBatch(); err == nil {
+				t.Fatal("runBatch succeeded, want an error")
+			}
+			if want := []string{"source rollback", "sink rollback"}; !reflect.DeepEqual(e.ordered(), want) {
+				t.Errorf("got %v, want %v", e.ordered(), want)
+			}
+		})
+	}
+}
+
+func TestRunBatchSinkCommitFailure(t *testing.T) {
+	e := new(events)
+	task := newFakeTask(&fakeSource{events: e}, &fakeSink{events: e, commitErr: errors.New("failure")})
+	_, retryable, err := task.runBatch()
+	if err == nil {
+		t.Fatal("runBatch succeeded, want an error")
+	}
+	if retryable {
+		t.Error("a failed sink commit is retryable")
+	}
+	if e.has("source commit") {
+		t.Errorf("the source has been committed: %v", e.ordered())
+	}
+	if !e.has("source rollback") {
+		t.Errorf("the source has not been rolled back: %v", e.ordered())
+	}
+}
+
+func TestRunBatchSourceCommitFailure(t *testing.T) {
+	e := new(events)
+	task := newFakeTask(&fakeSource{events: e, commitErr: errors.New("failure")}, &fakeSink{events: e})
+	if _, _, err := task.runBatch(); err == nil {
+		t.Fatal("runBatch succeeded, want an error")
+	}
+	if want := []string{"sink commit", "source commit", "source rollback"}; !reflect.DeepEqual(e.ordered(), want) {
+		t.Errorf("got %v, want %v", e.ordered(), want)
+	}
+}
+
+func TestRunBatchCheckpointFailure(t *testing.T) {
+	e := new(events)
+	task := newFakeTask(&fakeSource{events: e}, &fakeSink{events: e})
+	// the directory of the checkpoint does not exist, so saving it fails before anything is committed
+	task.cfg.Checkpoint = filepath.Join(t.TempDir(), "missing", "checkpoint")
+	task.checkpointer = newCheckpointer(task.cfg, data.Analysis{})
+	if _, _, err := task.runBatch(); err == nil {
+		t.Fatal("runBatch succeeded, want an error")
+	}
+	if want := []string{"source rollback", "sink rollback"}; !reflect.DeepEqual(e.ordered(), want) {
+		t.Errorf("got %v, want %v", e.ordered(), want)
+	}
+}
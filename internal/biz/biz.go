@@ -1,104 +1,279 @@
 package biz
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"os/signal"
+	"regexp"
 	"runtime"
-	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/dbadylan/go-mysql-archiver/internal/config"
 	"github.com/dbadylan/go-mysql-archiver/internal/data"
+	"github.com/dbadylan/go-mysql-archiver/internal/logger"
 )
 
-func Run(cfg *config.Config) (err error) {
-	sTime := time.Now().Local()
+func Run(ctx context.Context, cfg *config.Config) (err error) {
+	if cfg.Socket == "" {
+		// a job only listens on a socket it is given, the command always has one
+		cfg.Socket = fmt.Sprintf("/tmp/%s-%s-%s.sock", cfg.Source.Address, cfg.Source.Database, cfg.Source.Table)
+		if cfg.MultiTable() {
+			cfg.Socket = fmt.Sprintf("/tmp/%s-%s.sock", cfg.Source.Address, cfg.Source.Database)
+		}
+	}
+	j := NewJob(cfg, Hooks{}, nil)
+
+	// a signal stops the task after the batch in flight, so that nothing is left half-committed, and the
+	// socket file is removed and the statistics are written as usual. A second one cancels the statements in
+	// flight, the batch is rolled back
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	signalExit := make(chan struct{}, 1)
+	defer func() {
+		signal.Stop(signals)
+		signalExit <- struct{}{}
+	}()
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				if j.ctl.interrupted() != nil {
+					logger.Warn("the statements in flight are cancelled", "signal", sig)
+					j.ctl.abort()
+					continue
+				}
+				logger.Warn("the task will stop after the current batch, send the signal again to cancel it", "signal", sig)
+				j.ctl.interrupt(sig)
+			case <-signalExit:
+				return
+			}
+		}
+	}()
 
-	srcDB, e1 := data.NewDB(cfg.Source.MySQL)
+	err = j.Run(ctx)
+	return
+}
+
+// Run
+//  run the task until it has finished, it is cancelled along with ctx
+func (j *Job) Run(ctx context.Context) (err error) {
+	cfg, ctl := j.cfg, j.ctl
+	sTime, e := j.start()
+	if e != nil {
+		err = e
+		return
+	}
+	defer func() { j.end(err) }()
+
+	var (
+		tasks    []*task
+		throttle *throttler
+		m        = newMetrics()
+		stats    *statistics
+	)
+	if cfg.StatisticsFile != "" && !cfg.DryRun {
+		// written however the task ends, so that the scheduler always knows how it went
+		defer func() {
+			if stats == nil {
+				stats = newStatistics(cfg, sTime, time.Now().Local(), tasks, throttle, m)
+			}
+			stats.finish(err)
+			if e := writeStatistics(cfg.StatisticsFile, stats); e != nil && err == nil {
+				err = e
+			}
+		}()
+	}
+
+	defer ctl.cancel()
+	defer func() {
+		if sig := ctl.interrupted(); sig != nil && errors.Is(err, context.Canceled) {
+			err = &InterruptedError{Signal: sig}
+		}
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			ctl.abort()
+		case <-ctl.ctx.Done():
+		}
+	}()
+
+	srcDB, e1 := data.NewDB(ctl.ctx, cfg.Source.MySQL)
 	if e1 != nil {
 		err = e1
 		return
 	}
 	defer func() { _ = srcDB.Close() }()
 
-	tgtDB, e2 := data.NewDB(cfg.Target.MySQL)
+	var tgtDB *sql.DB
+	if !cfg.Purge && !cfg.DryRun && j.sink == nil && cfg.Target.Dir == "" {
+		if tgtDB, err = data.NewDB(ctl.ctx, cfg.Target.MySQL); err != nil {
+			return
+		}
+		defer func() { _ = tgtDB.Close() }()
+	}
+	// the sink given to the job is closed once, either after the last table to report its error or when the
+	// task fails, the file sinks of the tables are closed by their task
+	sink := j.sink
+	closeSink := func() (err error) {
+		if sink == nil {
+			return
+		}
+		s := sink
+		sink = nil
+		return s.Close()
+	}
+	defer func() {
+		for _, t := range tasks {
+			_ = t.closeSink()
+		}
+		_ = closeSink()
+	}()
+
+	tables, e2 := resolveTables(ctl.ctx, srcDB, cfg)
 	if e2 != nil {
 		err = e2
 		return
 	}
-	defer func() { _ = tgtDB.Close() }()
-
-	analysis, e3 := data.AnalyzeQuery(srcDB, cfg.Source.Database, cfg.Source.Table, cfg.Source.Where)
-	if e3 != nil {
-		err = e3
+	for _, table := range tables {
+		tableCfg := cfg
+		if cfg.MultiTable() {
+			tableCfg = cfg.ForTable(table)
+		}
+		var analysis data.Analysis
+		if analysis, err = analyze(ctl.ctx, tableCfg, srcDB); err != nil {
+			err = j.tableError(table, err)
+			return
+		}
+		if cfg.DryRun {
+			if err = printPlan(ctl.ctx, tableCfg, srcDB, analysis); err != nil {
+				err = j.tableError(table, err)
+				return
+			}
+			continue
+		}
+		var t *task
+		if t, err = j.newTask(tableCfg, analysis, srcDB, tgtDB, m); err != nil {
+			err = j.tableError(table, err)
+			return
+		}
+		tasks = append(tasks, t)
+	}
+	if cfg.DryRun {
 		return
 	}
 
-	socketFile := cfg.Socket
-	if socketFile == "" {
-		socketFile = fmt.Sprintf("/tmp/%s-%s-%s.sock", cfg.Source.Address, cfg.Source.Database, cfg.Source.Table)
-	}
-	listener, e4 := net.Listen("unix", socketFile)
-	if e4 != nil {
-		err = e4
+	if throttle, err = newThrottler(cfg, srcDB, ctl, j.hooks.OnThrottle); err != nil {
 		return
 	}
-	defer func() {
-		_ = listener.Close()
-		_ = os.Remove(socketFile)
-	}()
-	var (
-		pause  bool
-		resume = make(chan struct{}, 1)
-	)
-	go func() {
-		for {
-			conn, e1 := listener.Accept()
-			if e1 != nil {
-				return
-			}
-			buf := make([]byte, 128)
-			n, e2 := conn.Read(buf)
-			if e2 != nil {
-				fmt.Println(e2.Error())
-				continue
-			}
-			cmd := strings.TrimSpace(string(buf[:n]))
-			var response string
-			switch cmd {
-			case "pause":
-				response = "task has been paused\n"
-				pause = true
-			case "resume":
-				response = "task will be resumed\n"
-				if pause {
-					pause = false
-					resume <- struct{}{}
-				}
-			default:
-				response = "unknown command\n"
-			}
-			if _, e2 = conn.Write([]byte(response)); e2 != nil {
-				fmt.Println(e2.Error())
-				continue
-			}
-			_ = conn.Close()
+	defer throttle.close()
+	j.setTasks(tasks, throttle)
+
+	srv := &server{tasks: tasks, control: ctl, metrics: m, throttle: throttle}
+	if cfg.Socket != "" {
+		listener, e3 := net.Listen("unix", cfg.Socket)
+		if e3 != nil {
+			err = e3
+			return
 		}
-	}()
+		defer func() {
+			_ = listener.Close()
+			_ = os.Remove(cfg.Socket)
+		}()
+		go srv.serve(listener)
+	}
+	if cfg.HTTPListen != "" {
+		shutdown, e4 := srv.serveHTTP(cfg.HTTPListen, sTime)
+		if e4 != nil {
+			err = e4
+			return
+		}
+		defer shutdown()
+	}
 
-	var rowsSelect int64
 	if cfg.Progress != 0 {
 		var exitChan = make(chan struct{}, 1)
 		defer func() { exitChan <- struct{}{} }()
 		go func() {
 			ticker := time.NewTicker(cfg.Progress)
 			defer ticker.Stop()
+			r := new(rate)
+			r.update(0, time.Now())
 			for {
 				select {
 				case ts := <-ticker.C:
-					fmt.Printf("[%s] progress: %d/%d\n", ts.Local().Format(config.TimeFormat), rowsSelect, analysis.RowsEstimated)
+					c := total(tasks)
+					label, rows := "progress", c.archived(cfg.NoDelete)
+					if cfg.NoDelete {
+						label = "copied"
+					}
+					r.update(rows, ts)
+					fields := []interface{}{
+						"rows", rows, "estimated", c.Estimated, "percent", fmt.Sprintf("%.2f%%", percent(rows, c.Estimated)),
+						"rate", fmt.Sprintf("%.0f/s", r.instant), "avg_rate", fmt.Sprintf("%.0f/s", r.average),
+					}
+					if left, ok := r.eta(rows, c.Estimated); ok {
+						fields = append(fields, "eta", left)
+					}
+					if len(tasks) > 1 {
+						fields = append(fields, "tables", fmt.Sprintf("%d/%d", finished(tasks), len(tasks)))
+					}
+					if c.Retry > 0 {
+						fields = append(fields, "retries", c.Retry)
+					}
+					status := throttle.snapshot()
+					if status.LagWait > 0 {
+						fields = append(fields, "replica_lag_wait", status.LagWait.Truncate(time.Second))
+					}
+					if status.LoadWait > 0 {
+						fields = append(fields, "source_load_wait", status.LoadWait.Truncate(time.Second))
+					}
+					if status.State != "" {
+						fields = append(fields, "throttled", status.State)
+					}
+					logger.Info(label, fields...)
+				case <-exitChan:
+					return
+				}
+			}
+		}()
+	}
+
+	if cfg.EstimateInterval > 0 {
+		var exitChan = make(chan struct{}, 1)
+		defer func() { exitChan <- struct{}{} }()
+		go func() {
+			ticker := time.NewTicker(cfg.EstimateInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					for _, t := range tasks {
+						if state, _ := t.progress.status(); state != tablePending && state != tableRunning {
+							continue
+						}
+						// the rows archived so far are gone or behind the cursor, what is left comes on top of them
+						done := t.progress.snapshot().archived(cfg.NoDelete)
+						estimateCtx, cancel := ctl.statement()
+						left, e := data.EstimateRows(estimateCtx, srcDB, t.cfg.Source.Table, t.cfg.Source.Where, t.analysis, t.progress.position(), cfg.EstimateCount)
+						cancel()
+						var fields []interface{}
+						if len(tasks) > 1 {
+							fields = append(fields, "table", t.cfg.Source.Table)
+						}
+						if e != nil {
+							logger.Warn("estimate", append(fields, "error", e)...)
+							continue
+						}
+						t.progress.setEstimated(done + left)
+						logger.Info("estimate", append(fields, "rows", done+left, "left", left)...)
+					}
 				case <-exitChan:
 					return
 				}
@@ -122,8 +297,8 @@ func Run(cfg *config.Config) (err error) {
 					runtime.ReadMemStats(memStats)
 					increased := memStats.Alloc - procMem
 					if increased > uint64(cfg.Memory) {
-						fmt.Printf("the memory usage(%d) of the task has exceeded the limit(%d), you can either reduce the batch size or increase the memory limit\n", increased, cfg.Memory)
-						os.Exit(1)
+						logger.Error("the memory usage of the task has exceeded the limit, you can either reduce the batch size or increase the memory limit", "usage", increased, "limit", cfg.Memory)
+						os.Exit(ExitFailure)
 					}
 				case <-exitChan:
 					return
@@ -132,149 +307,219 @@ func Run(cfg *config.Config) (err error) {
 		}()
 	}
 
-	var (
-		rowsInsert int64
-		rowsDelete int64
-		sleep      = new(time.Ticker)
-		runTime    = new(time.Ticker)
-	)
-	if cfg.Sleep > 0 {
-		sleep = time.NewTicker(cfg.Sleep)
-		defer sleep.Stop()
-	}
 	if cfg.RunTime > 0 {
-		runTime = time.NewTicker(cfg.RunTime)
+		// the batch in flight is not waited for, it is rolled back
+		runTime := time.AfterFunc(cfg.RunTime, func() {
+			logger.Info("the run time is up, the statements in flight are cancelled", "run_time", cfg.RunTime)
+			ctl.abort()
+		})
 		defer runTime.Stop()
 	}
-L:
-	for {
-		select {
-		case <-runTime.C:
-			break L
-		default:
-			selectParam := &data.SelectParam{
-				DB:       srcDB,
-				Table:    cfg.Source.Table,
-				Where:    cfg.Source.Where,
-				Limit:    cfg.Source.Limit,
-				Analysis: analysis,
-			}
-			resp, e1 := data.SelectRows(selectParam)
-			if e1 != nil {
-				err = e1
-				return
-			}
-			if resp.Rows == 0 {
-				break L
-			}
-			rowsSelect += resp.Rows
-
-			srcTx, e2 := srcDB.Begin()
-			if e2 != nil {
-				err = e2
-				return
-			}
-			tgtTx, e3 := tgtDB.Begin()
-			if e3 != nil {
-				err = e3
-				return
-			}
+	if err = j.runTables(tasks, throttle); err != nil {
+		return
+	}
 
-			insertParam := &data.InsertParam{
-				Tx:        tgtTx,
-				Table:     cfg.Target.Table,
-				Columns:   resp.Insert.Columns,
-				Values:    resp.Insert.Values,
-				ValueList: resp.Insert.ValueList,
-			}
-			deleteParam := &data.DeleteParam{
-				Tx:        srcTx,
-				Table:     cfg.Source.Table,
-				Where:     resp.Delete.Where,
-				Limit:     cfg.Source.Limit,
-				ValueList: resp.Delete.ValueList,
-				Analysis:  analysis,
-			}
+	if err = closeSink(); err != nil {
+		return
+	}
 
-			var (
-				wg      = new(sync.WaitGroup)
-				inserts int64
-				deletes int64
-				errs    []string
-			)
-			wg.Add(1)
-			go func(wg *sync.WaitGroup, param *data.InsertParam, inserts *int64, errs *[]string) {
-				defer wg.Done()
-				rowsAffected, e := data.InsertRows(param)
-				if e != nil {
-					*errs = append(*errs, e.Error())
-					return
-				}
-				*inserts = rowsAffected
-				return
-			}(wg, insertParam, &inserts, &errs)
+	eTime := time.Now().Local()
 
-			wg.Add(1)
-			go func(wg *sync.WaitGroup, param *data.DeleteParam, deletes *int64, errs *[]string) {
-				defer wg.Done()
-				rowsAffected, e := data.DeleteRows(param)
-				if e != nil {
-					*errs = append(*errs, e.Error())
-					return
-				}
-				*deletes = rowsAffected
-			}(wg, deleteParam, &deletes, &errs)
+	if sig := ctl.interrupted(); sig != nil {
+		err = &InterruptedError{Signal: sig}
+	} else if ctx.Err() != nil {
+		// cancelled by the caller
+		err = ctx.Err()
+	}
+	for _, t := range tasks {
+		if t.reconciler == nil {
+			continue
+		}
+		if len(tasks) > 1 {
+			fmt.Printf("\n%s\n", t.cfg.Source.Table)
+		}
+		// the statistics are still printed when the target does not match, the report is not cancelled along
+		// with the statements of the task
+		if e := t.reconciler.report(ctx); e != nil {
+			err = j.tableError(t.cfg.Source.Table, e)
+		}
+	}
 
-			wg.Wait()
+	stats = newStatistics(cfg, sTime, eTime, tasks, throttle, m)
+	stats.finish(err)
+	if !cfg.Statistics {
+		return
+	}
+	stats.print()
 
-			if len(errs) != 0 {
-				err = fmt.Errorf(strings.Join(errs, "\n"))
-				return
-			}
-			if inserts < deletes {
-				err = fmt.Errorf("rows deleted(%d) larger than inserted(%d), rollback and exit", deletes, inserts)
-				return
-			}
+	return
+}
 
-			if err = tgtTx.Commit(); err != nil {
-				return
+// resolveTables
+//  the tables of the task, the ones listed by src-table in their order, or the ones of the database matching
+//  src-table-regex in the order of their names
+func resolveTables(ctx context.Context, srcDB *sql.DB, cfg *config.Config) (tables []string, err error) {
+	switch {
+	case !cfg.MultiTable():
+		tables = []string{cfg.Source.Table}
+		return
+	case len(cfg.Source.Tables) != 0:
+		seen := make(map[string]bool, len(cfg.Source.Tables))
+		for _, table := range cfg.Source.Tables {
+			if !seen[table] {
+				seen[table] = true
+				tables = append(tables, table)
 			}
-			rowsInsert += inserts
+		}
+		return
+	}
+	var all []string
+	if all, err = data.ListTables(ctx, srcDB, cfg.Source.Database); err != nil {
+		return
+	}
+	// the whole name has to match, so that log_ does not choose log_archive_config
+	re := regexp.MustCompile("^(?:" + cfg.Source.TableRegex + ")$")
+	for _, table := range all {
+		if re.MatchString(table) {
+			tables = append(tables, table)
+		}
+	}
+	if len(tables) == 0 {
+		err = fmt.Errorf("src-table-regex: no table of database %s matches %q", cfg.Source.Database, cfg.Source.TableRegex)
+	}
+	return
+}
 
-			if err = srcTx.Commit(); err != nil {
-				return
-			}
-			rowsDelete += deletes
+// tableError
+//  name the table in the error when the task archives several tables
+func (j *Job) tableError(table string, err error) error {
+	if !j.cfg.MultiTable() {
+		return err
+	}
+	return fmt.Errorf("table %s: %w", table, err)
+}
 
-			if resp.Rows < cfg.Source.Limit {
-				break L
-			}
+// analyze
+//  choose how to page through the table and check that it suits the options
+func analyze(ctx context.Context, cfg *config.Config, srcDB *sql.DB) (analysis data.Analysis, err error) {
+	if analysis, err = data.AnalyzeQuery(ctx, srcDB, cfg.Source.Database, cfg.Source.Table, cfg.Source.Where); err != nil {
+		return
+	}
 
-			if pause {
-				<-resume
-				continue
-			}
+	if cfg.NoDelete && analysis.QueryType != 1 {
+		err = fmt.Errorf("table %s has no non-nullable unique key to page through without deleting", cfg.Source.Table)
+		return
+	}
+	if cfg.Verify && analysis.QueryType != 1 {
+		err = fmt.Errorf("table %s has no non-nullable unique key to verify the rows by", cfg.Source.Table)
+		return
+	}
+	if cfg.Reconcile && analysis.QueryType != 1 {
+		err = fmt.Errorf("table %s has no non-nullable unique key to reconcile the rows by", cfg.Source.Table)
+		return
+	}
+	return
+}
 
-			if cfg.Sleep != 0 {
-				<-sleep.C
-				continue
-			}
+// newTask
+//  get the task of a table ready, the XA branches and the batch left in flight by the last run are finished first
+func (j *Job) newTask(cfg *config.Config, analysis data.Analysis, srcDB *sql.DB, tgtDB *sql.DB, m *metrics) (t *task, err error) {
+	ctl := j.ctl
+	var xaGen *xaGenerator
+	if cfg.XA {
+		xaGen = newXAGenerator(cfg)
+		if err = recoverXA(ctl.ctx, srcDB, tgtDB, xaGen.prefix); err != nil {
+			return
 		}
 	}
 
-	eTime := time.Now().Local()
+	t = &task{
+		cfg:          cfg,
+		analysis:     analysis,
+		source:       &data.MySQLSource{DB: srcDB, Table: cfg.Source.Table, Where: cfg.Source.Where, Analysis: analysis},
+		xaGen:        xaGen,
+		checkpointer: newCheckpointer(cfg, analysis),
+		control:      ctl,
+		progress:     new(progress),
+		metrics:      m,
+		hooks:        j.hooks,
+	}
+	t.progress.setEstimated(analysis.RowsEstimated)
+	if cfg.Reconcile {
+		t.reconciler = newReconciler(cfg.ReconcileChunk, tgtDB, cfg.Target.Table, analysis)
+	}
+	if cfg.Resume {
+		var rowsDelete int64
+		if t.cursor, rowsDelete, err = t.checkpointer.resume(ctl, srcDB, tgtDB, cfg, analysis); err != nil {
+			return
+		}
+		t.progress.addDelete(rowsDelete)
+	}
 
-	if !cfg.Statistics {
-		return
+	switch {
+	case cfg.Purge:
+		// nothing to copy to
+	case j.sink != nil:
+		t.sink = j.sink
+	case tgtDB != nil:
+		t.sink = &data.MySQLSink{DB: tgtDB, Table: cfg.Target.Table, Analysis: analysis}
+	default:
+		sinkParam := &data.FileSinkParam{
+			Dir:      cfg.Target.Dir,
+			Format:   cfg.Target.Format,
+			Compress: cfg.Target.Compress,
+			MaxSize:  cfg.Target.FileSize,
+			Name:     cfg.Source.Database + "." + cfg.Source.Table,
+			Table:    cfg.Target.Table,
+		}
+		if t.sink, err = data.NewFileSink(sinkParam); err != nil {
+			return
+		}
+		t.ownsSink = true
 	}
+	return
+}
 
-	fmt.Printf(
-		config.StatisticsTemplate,
-		sTime.Format(config.TimeFormat), eTime.Format(config.TimeFormat), eTime.Sub(sTime).Truncate(time.Second).String(),
-		cfg.Source.Address, cfg.Source.Database, cfg.Source.Table, cfg.Source.Charset,
-		cfg.Target.Address, cfg.Target.Database, cfg.Target.Table, cfg.Target.Charset,
-		rowsSelect, rowsInsert, rowsDelete,
+// runTables
+//  run the tables one after another, or up to concurrency of them at the same time. The first table that fails
+//  stops the task, the other tables stop after their batch in flight and no other table is started
+func (j *Job) runTables(tasks []*task, throttle *throttler) (err error) {
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		slots = make(chan struct{}, j.cfg.Concurrency)
 	)
-
+	for _, t := range tasks {
+		slots <- struct{}{}
+		if _, stopped := j.ctl.state(); stopped {
+			break
+		}
+		wg.Add(1)
+		go func(t *task) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			if len(tasks) > 1 {
+				logger.Info("archiving", "table", t.cfg.Source.Table, "estimated", t.progress.snapshot().Estimated)
+			}
+			e := t.run(throttle)
+			if e == nil {
+				if len(tasks) > 1 {
+					state, _ := t.progress.status()
+					c := t.progress.snapshot()
+					logger.Info("table "+state, "table", t.cfg.Source.Table, "rows", c.Select, "inserted", c.Insert, "deleted", c.Delete)
+				}
+				return
+			}
+			mu.Lock()
+			if err == nil {
+				err = j.tableError(t.cfg.Source.Table, e)
+			}
+			mu.Unlock()
+			j.ctl.stop()
+		}(t)
+	}
+	wg.Wait()
 	return
 }
@@ -0,0 +1,144 @@
+package biz
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dbadylan/go-mysql-archiver/internal/config"
+	"github.com/dbadylan/go-mysql-archiver/internal/data"
+	"github.com/dbadylan/go-mysql-archiver/internal/logger"
+)
+
+type checkpointer struct {
+	file       string
+	checkpoint data.Checkpoint
+}
+
+func newCheckpointer(cfg *config.Config, analysis data.Analysis) *checkpointer {
+	return &checkpointer{
+		file: cfg.Checkpoint,
+		checkpoint: data.Checkpoint{
+			Source: fmt.Sprintf("%s/%s/%s", cfg.Source.Address, cfg.Source.Database, cfg.Source.Table),
+			Target: fmt.Sprintf("%s/%s/%s", cfg.Target.Address, cfg.Target.Database, cfg.Target.Table),
+			Where:  cfg.Source.Where,
+			Key:    analysis.Columns,
+		},
+	}
+}
+
+func (c *checkpointer) save(phase string, cursor [][]byte, batch *data.Batch) (err error) {
+	if c.file == "" {
+		return
+	}
+	c.checkpoint.Phase = phase
+	c.checkpoint.Cursor = cursor
+	c.checkpoint.Batch = batch
+	err = data.SaveCheckpoint(c.file, &c.checkpoint)
+	return
+}
+
+// resume
+//  reconcile the batch left in flight by the last run, and return the cursor to carry on from, the task starts
+//  from the beginning when there is no checkpoint file yet
+func (c *checkpointer) resume(ctl *control, srcDB *sql.DB, tgtDB *sql.DB, cfg *config.Config, analysis data.Analysis) (cursor [][]byte, rowsDelete int64, err error) {
+	var checkpoint *data.Checkpoint
+	if checkpoint, err = data.LoadCheckpoint(c.file); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			logger.Info("no checkpoint to resume from, starting from the beginning", "checkpoint", c.file)
+			err = nil
+		}
+		return
+	}
+	if checkpoint.Source != c.checkpoint.Source || checkpoint.Target != c.checkpoint.Target {
+		err = fmt.Errorf("the checkpoint belongs to another task(%s -> %s)", checkpoint.Source, checkpoint.Target)
+		return
+	}
+	if checkpoint.Where != c.checkpoint.Where {
+		err = fmt.Errorf("the checkpoint was taken with another where(%s)", checkpoint.Where)
+		return
+	}
+	if strings.Join(checkpoint.Key, ",") != strings.Join(c.checkpoint.Key, ",") {
+		err = fmt.Errorf("the checkpoint was taken by another key(%s)", strings.Join(checkpoint.Key, ","))
+		return
+	}
+
+	cursor = checkpoint.Cursor
+	batch := checkpoint.Batch
+	phase := checkpoint.Phase
+	if phase != data.PhaseCommitted && batch == nil {
+		err = fmt.Errorf("the checkpoint is in phase %s but has no batch", phase)
+		return
+	}
+	if phase == data.PhasePrepared && tgtDB == nil {
+		// archiving to local files, re-archiving the batch may only duplicate some rows in the files
+		phase = data.PhaseCommitted
+	}
+	if phase == data.PhasePrepared {
+		// the last run may have died in the middle of committing the target
+		if analysis.QueryType != 1 {
+			err = fmt.Errorf("the last batch stopped in phase %s and the table has no unique key to tell whether the target has committed it, please check the target table manually", phase)
+			return
+		}
+		var (
+			count int64
+			keys  = int64(len(batch.Values) / len(analysis.Columns))
+		)
+		ctx, cancel := ctl.statement()
+		count, err = data.CountKeys(ctx, tgtDB, cfg.Target.Table, analysis, batch.Values)
+		cancel()
+		if err != nil {
+			return
+		}
+		switch count {
+		case 0:
+			phase = data.PhaseCommitted
+		case keys:
+			phase = data.PhaseTargetCommitted
+		default:
+			err = fmt.Errorf("the last batch stopped in phase %s, but only %d of its %d rows are found in the target", phase, count, keys)
+			return
+		}
+	}
+
+	switch phase {
+	case data.PhaseCommitted:
+		// nothing is half-committed
+	case data.PhaseTargetCommitted:
+		if cfg.NoDelete {
+			cursor = batch.Cursor
+			break
+		}
+		ctx, cancel := ctl.statement()
+		defer cancel()
+		var srcTx *sql.Tx
+		if srcTx, err = srcDB.BeginTx(ctx, nil); err != nil {
+			return
+		}
+		deleteParam := &data.DeleteParam{
+			Tx:       srcTx,
+			Table:    cfg.Source.Table,
+			Where:    &batch.Where,
+			Limit:    batch.Rows,
+			Values:   batch.Values,
+			Analysis: analysis,
+		}
+		if rowsDelete, err = data.DeleteRows(ctx, deleteParam); err != nil {
+			_ = srcTx.Rollback()
+			return
+		}
+		if err = srcTx.Commit(); err != nil {
+			return
+		}
+		cursor = batch.Cursor
+		logger.Info("reconciled the half-committed batch", "deleted", rowsDelete)
+	default:
+		err = fmt.Errorf("unknown checkpoint phase %q", phase)
+		return
+	}
+
+	err = c.save(data.PhaseCommitted, cursor, nil)
+	return
+}
@@ -0,0 +1,160 @@
+package biz
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dbadylan/go-mysql-archiver/internal/config"
+)
+
+// control
+//  the parameters that can be changed while the task is running, and whether it is paused or stopped
+type control struct {
+	cfg *config.Config
+	// ctx is what the statements run with, it is cancelled to interrupt the ones in flight
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	paused  bool
+	stopped bool
+	// resume is closed once the task is resumed, so that every table waiting in pause carries on
+	resume chan struct{}
+	// done is closed once the task is stopped, waits between batches select on it
+	done chan struct{}
+	// signal is the signal that stopped the task, nil when it was not stopped by a signal
+	signal os.Signal
+}
+
+func newControl(cfg *config.Config) *control {
+	c := &control{cfg: cfg, resume: make(chan struct{}), done: make(chan struct{})}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c
+}
+
+// statement
+//  the context of a statement, which is cancelled once it has run longer than --query-timeout
+func (c *control) statement() (context.Context, context.CancelFunc) {
+	if c.cfg.QueryTimeout > 0 {
+		return context.WithTimeout(c.ctx, c.cfg.QueryTimeout)
+	}
+	return context.WithCancel(c.ctx)
+}
+
+func (c *control) limit() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cfg.Source.Limit
+}
+
+func (c *control) setLimit(limit int64) {
+	c.mu.Lock()
+	c.cfg.Source.Limit = limit
+	c.mu.Unlock()
+}
+
+func (c *control) sleep() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cfg.Sleep
+}
+
+func (c *control) setSleep(sleep time.Duration) {
+	c.mu.Lock()
+	c.cfg.Sleep = sleep
+	c.mu.Unlock()
+}
+
+func (c *control) pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped || c.paused {
+		return
+	}
+	c.paused = true
+	c.resume = make(chan struct{})
+}
+
+// wake
+//  let the task carry on if it is waiting in pause
+func (c *control) wake() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.paused {
+		return
+	}
+	c.paused = false
+	close(c.resume)
+}
+
+// stop
+//  finish the task after the batch in flight, a paused task is woken up to exit
+func (c *control) stop() {
+	c.mu.Lock()
+	if !c.stopped {
+		c.stopped = true
+		close(c.done)
+	}
+	c.mu.Unlock()
+	c.wake()
+}
+
+// interrupt
+//  stop the task on a signal, the first one is remembered
+func (c *control) interrupt(sig os.Signal) {
+	c.mu.Lock()
+	if c.signal == nil {
+		c.signal = sig
+	}
+	c.mu.Unlock()
+	c.stop()
+}
+
+// abort
+//  stop the task at once, the statements in flight are cancelled and their batch is rolled back
+func (c *control) abort() {
+	c.stop()
+	c.cancel()
+}
+
+func (c *control) aborted() bool {
+	return c.ctx.Err() != nil
+}
+
+func (c *control) interrupted() os.Signal {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.signal
+}
+
+// wait
+//  wait for d, return false if the task is stopped in the meantime
+func (c *control) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+func (c *control) state() (paused bool, stopped bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused, c.stopped
+}
+
+// waitPaused
+//  block while the task is paused
+func (c *control) waitPaused() {
+	c.mu.Lock()
+	paused, resume := c.paused, c.resume
+	c.mu.Unlock()
+	if paused {
+		<-resume
+	}
+}
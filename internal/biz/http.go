@@ -0,0 +1,132 @@
+package biz
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/dbadylan/go-mysql-archiver/internal/logger"
+)
+
+// progressStatus
+//  the answer of GET /progress
+type progressStatus struct {
+	Batch     int64   `json:"batch"`
+	Select    int64   `json:"select"`
+	Insert    int64   `json:"insert"`
+	Delete    int64   `json:"delete"`
+	Estimated int64   `json:"estimated"`
+	Percent   float64 `json:"percent"`
+	Elapsed   string  `json:"elapsed"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func writeMessage(w http.ResponseWriter, code int, key string, message string) {
+	writeJSON(w, code, map[string]string{key: message})
+}
+
+// allow
+//  reject the requests whose method is not the given one
+func allow(method string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeMessage(w, http.StatusMethodNotAllowed, "error", fmt.Sprintf("method %s is not allowed, use %s", r.Method, method))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// handler
+//  the HTTP counterpart of the control socket:
+//   GET  /status                  the same JSON as the status command
+//   GET  /progress                the counters and the percentage of the rows deleted, or copied with no-delete
+//   POST /pause, /resume, /stop   the same as the commands
+//   POST /parameters?limit=1000   change one or more of limit, sleep, max-lag and max-load
+//   GET  /metrics                 the metrics in the Prometheus text format
+func (s *server) handler(start time.Time) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", allow(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.status())
+	}))
+	mux.HandleFunc("/progress", allow(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		c := total(s.tasks)
+		p := progressStatus{
+			Batch:     c.Batches,
+			Select:    c.Select,
+			Insert:    c.Insert,
+			Delete:    c.Delete,
+			Estimated: c.Estimated,
+			Percent:   percent(c.archived(s.control.cfg.NoDelete), c.Estimated),
+			Elapsed:   time.Since(start).Truncate(time.Second).String(),
+		}
+		writeJSON(w, http.StatusOK, p)
+	}))
+	mux.HandleFunc("/metrics", allow(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = w.Write(s.exposition())
+	}))
+	for _, cmd := range []string{"pause", "resume", "stop", "cancel"} {
+		cmd := cmd
+		mux.HandleFunc("/"+cmd, allow(http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+			writeMessage(w, http.StatusOK, "message", s.handle(cmd))
+		}))
+	}
+	mux.HandleFunc("/parameters", allow(http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			writeMessage(w, http.StatusBadRequest, "error", err.Error())
+			return
+		}
+		if len(r.Form) == 0 {
+			writeMessage(w, http.StatusBadRequest, "error", "no parameter is given, it should be one or more of limit, sleep, max-lag and max-load")
+			return
+		}
+		names := make([]string, 0, len(r.Form))
+		for name := range r.Form {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		// every parameter is checked before any of them is changed
+		applies := make([]func() string, 0, len(names))
+		for _, name := range names {
+			apply, err := s.parameter(name, r.Form.Get(name))
+			if err != nil {
+				writeMessage(w, http.StatusBadRequest, "error", err.Error())
+				return
+			}
+			applies = append(applies, apply)
+		}
+		messages := make([]string, 0, len(applies))
+		for _, apply := range applies {
+			messages = append(messages, apply())
+		}
+		writeJSON(w, http.StatusOK, map[string][]string{"messages": messages})
+	}))
+	return mux
+}
+
+// serveHTTP
+//  serve the HTTP API until the returned function is called
+func (s *server) serveHTTP(address string, start time.Time) (shutdown func(), err error) {
+	var listener net.Listener
+	if listener, err = net.Listen("tcp", address); err != nil {
+		return
+	}
+	srv := &http.Server{Handler: s.handler(start), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if e := srv.Serve(listener); e != nil && e != http.ErrServerClosed {
+			logger.Error("http api", "error", e)
+		}
+	}()
+	shutdown = func() { _ = srv.Close() }
+	return
+}
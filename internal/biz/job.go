@@ -0,0 +1,200 @@
+package biz
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dbadylan/go-mysql-archiver/internal/config"
+	"github.com/dbadylan/go-mysql-archiver/internal/data"
+)
+
+// Hooks
+//  called by the task as it goes, they are run in the goroutine of the table and should return quickly, they
+//  are called from several goroutines at the same time when several tables are archived at the same time
+type Hooks struct {
+	// OnBatch is called after a batch has been committed
+	OnBatch func(batch Batch)
+	// OnError is called every time a batch has failed, including the attempts that are retried, the error that
+	// ends the task is returned by Run
+	OnError func(err error)
+	// OnThrottle is called when the task starts waiting for the replicas or the source load, with the reason, and
+	// with an empty reason once it carries on
+	OnThrottle func(reason string)
+}
+
+// Batch
+//  a committed batch
+type Batch struct {
+	Table string
+	// Number counts the batches of the table
+	Number   int64
+	Rows     int64
+	Inserted int64
+	Deleted  int64
+	// Cursor is the key of the last row of the batch, empty when the table is not paged through a key
+	Cursor string
+	// Took includes the retries of the batch
+	Took time.Duration
+}
+
+// TableStats
+//  what a job has done so far to one of its tables
+type TableStats struct {
+	Table string
+	// State is one of pending, running, done, stopped and failed
+	State     string
+	Batches   int64
+	Selected  int64
+	Inserted  int64
+	Deleted   int64
+	Retries   int64
+	Errors    int64
+	Estimated int64
+	Cursor    string
+	// Err is what the table has failed with
+	Err error
+}
+
+// Stats
+//  what a job has done so far, it can be taken while the job is running, the counters add up every table
+type Stats struct {
+	// State is one of pending, running, paused, throttled, stopping and finished
+	State     string
+	Batches   int64
+	Selected  int64
+	Inserted  int64
+	Deleted   int64
+	Retries   int64
+	Errors    int64
+	Estimated int64
+	// Cursor is the key of the last row committed, empty when there are several tables
+	Cursor string
+	Begin  time.Time
+	// Finish is zero until the job has finished
+	Finish time.Time
+	// Err is what Run has returned
+	Err error
+	// Tables are empty until the tables have been analyzed
+	Tables []TableStats
+}
+
+// Job
+//  a task that can be paused, resumed and stopped from other goroutines while it is running, it runs only once
+type Job struct {
+	cfg   *config.Config
+	hooks Hooks
+	// sink replaces the target of the config when it is not nil
+	sink data.RowSink
+	ctl  *control
+
+	mu       sync.Mutex
+	started  bool
+	begin    time.Time
+	finish   time.Time
+	tasks    []*task
+	throttle *throttler
+	err      error
+}
+
+func NewJob(cfg *config.Config, hooks Hooks, sink data.RowSink) *Job {
+	return &Job{cfg: cfg, hooks: hooks, sink: sink, ctl: newControl(cfg)}
+}
+
+// Pause
+//  hold the task after the batch in flight
+func (j *Job) Pause() {
+	j.ctl.pause()
+}
+
+func (j *Job) Resume() {
+	j.ctl.wake()
+}
+
+// Stop
+//  finish the task after the batch in flight
+func (j *Job) Stop() {
+	j.ctl.stop()
+}
+
+// Cancel
+//  finish the task at once, the statements in flight are cancelled and their batch is rolled back
+func (j *Job) Cancel() {
+	j.ctl.abort()
+}
+
+func (j *Job) start() (begin time.Time, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.started {
+		err = errors.New("the job has already been run")
+		return
+	}
+	j.started = true
+	j.begin = time.Now().Local()
+	begin = j.begin
+	return
+}
+
+func (j *Job) setTasks(tasks []*task, throttle *throttler) {
+	j.mu.Lock()
+	j.tasks, j.throttle = tasks, throttle
+	j.mu.Unlock()
+}
+
+func (j *Job) end(err error) {
+	j.mu.Lock()
+	j.finish = time.Now().Local()
+	j.err = err
+	j.mu.Unlock()
+}
+
+// state
+//  one of running, paused, throttled and stopping
+func state(ctl *control, throttle *throttler) string {
+	paused, stopped := ctl.state()
+	switch {
+	case stopped:
+		return "stopping"
+	case paused:
+		return "paused"
+	case throttle != nil && throttle.snapshot().State != "":
+		return "throttled"
+	default:
+		return "running"
+	}
+}
+
+func (j *Job) Stats() (stats Stats) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	stats.Begin, stats.Finish, stats.Err = j.begin, j.finish, j.err
+	switch {
+	case !j.started:
+		stats.State = "pending"
+	case !j.finish.IsZero():
+		stats.State = "finished"
+	default:
+		stats.State = state(j.ctl, j.throttle)
+	}
+	c := total(j.tasks)
+	stats.Batches, stats.Selected, stats.Inserted, stats.Deleted = c.Batches, c.Select, c.Insert, c.Delete
+	stats.Retries, stats.Errors, stats.Estimated, stats.Cursor = c.Retry, c.Errors, c.Estimated, c.Cursor
+	for _, t := range j.tasks {
+		tc := t.progress.snapshot()
+		table := TableStats{
+			Table:     t.cfg.Source.Table,
+			Batches:   tc.Batches,
+			Selected:  tc.Select,
+			Inserted:  tc.Insert,
+			Deleted:   tc.Delete,
+			Retries:   tc.Retry,
+			Errors:    tc.Errors,
+			Estimated: tc.Estimated,
+			Cursor:    tc.Cursor,
+		}
+		table.State, table.Err = t.progress.status()
+		stats.Tables = append(stats.Tables, table)
+	}
+	return
+}
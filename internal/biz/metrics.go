@@ -0,0 +1,200 @@
+package biz
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	phaseSelect = "select"
+	phaseInsert = "insert"
+	phaseDelete = "delete"
+	phaseCommit = "commit"
+
+	// actionWrite is the wall time of insert and delete, which run at the same time
+	actionWrite         = "insert+delete"
+	actionPrepareTarget = "prepare_target"
+	actionPrepareSource = "prepare_source"
+	actionCommitTarget  = "commit_target"
+	actionCommitSource  = "commit_source"
+	actionSleep         = "sleep"
+	actionPause         = "pause"
+	actionThrottle      = "throttle"
+)
+
+// actions
+//  what the time of a task is spent on, in the order of the statistics
+var actions = []string{phaseSelect, phaseInsert, phaseDelete, actionWrite, actionPrepareTarget, actionPrepareSource, actionCommitTarget, actionCommitSource, actionSleep, actionPause, actionThrottle}
+
+// durationBuckets
+//  the upper bounds in seconds of the histogram buckets
+var durationBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type action struct {
+	count int64
+	total time.Duration
+}
+
+// metrics
+//  the durations of the statements and the total time of every action, the counters come from progress and are
+//  read when scraped
+type metrics struct {
+	mu         sync.Mutex
+	histograms map[string]*histogram
+	actions    map[string]*action
+}
+
+func newMetrics() *metrics {
+	m := &metrics{histograms: make(map[string]*histogram), actions: make(map[string]*action)}
+	for _, phase := range []string{phaseSelect, phaseInsert, phaseDelete, phaseCommit} {
+		m.histograms[phase] = &histogram{counts: make([]uint64, len(durationBuckets))}
+	}
+	for _, name := range actions {
+		m.actions[name] = new(action)
+	}
+	return m
+}
+
+// add
+//  count the time spent on an action that has no histogram
+func (m *metrics) add(name string, d time.Duration) {
+	m.mu.Lock()
+	a := m.actions[name]
+	a.count++
+	a.total += d
+	m.mu.Unlock()
+}
+
+// time
+//  run fn and count its time as the action
+func (m *metrics) time(name string, fn func() error) error {
+	begin := time.Now()
+	defer func() { m.add(name, time.Since(begin)) }()
+	return fn()
+}
+
+func (m *metrics) timings() map[string]action {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	timings := make(map[string]action, len(m.actions))
+	for name, a := range m.actions {
+		timings[name] = *a
+	}
+	return timings
+}
+
+// observe
+//  record the duration of a statement, which is also counted as the action of the same name if there is one
+func (m *metrics) observe(phase string, d time.Duration) {
+	seconds := d.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if a, ok := m.actions[phase]; ok {
+		a.count++
+		a.total += d
+	}
+	h := m.histograms[phase]
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func writeMetric(buf *bytes.Buffer, name string, kind string, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// exposition
+//  render every metric in the Prometheus text format
+func (s *server) exposition() []byte {
+	var (
+		buf      bytes.Buffer
+		c        = total(s.tasks)
+		throttle = s.throttle.snapshot()
+		lags     = s.throttle.replicaLags()
+		paused   bool
+		memStats = new(runtime.MemStats)
+	)
+	paused, _ = s.control.state()
+	runtime.ReadMemStats(memStats)
+
+	for _, counter := range []struct {
+		name  string
+		help  string
+		value int64
+	}{
+		{"archiver_rows_selected_total", "Rows selected from the source.", c.Select},
+		{"archiver_rows_inserted_total", "Rows inserted into the target.", c.Insert},
+		{"archiver_rows_deleted_total", "Rows deleted from the source.", c.Delete},
+		{"archiver_batches_total", "Batches committed.", c.Batches},
+		{"archiver_retries_total", "Batches retried after transient errors.", c.Retry},
+		{"archiver_errors_total", "Batch attempts that failed, including the retried ones.", c.Errors},
+	} {
+		writeMetric(&buf, counter.name, "counter", counter.help)
+		fmt.Fprintf(&buf, "%s %d\n", counter.name, counter.value)
+	}
+
+	name := "archiver_statement_duration_seconds"
+	writeMetric(&buf, name, "histogram", "Duration of the statements of a batch, commit covers both sides.")
+	s.metrics.mu.Lock()
+	for _, phase := range []string{phaseSelect, phaseInsert, phaseDelete, phaseCommit} {
+		h := s.metrics.histograms[phase]
+		var cumulative uint64
+		for i, bound := range durationBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&buf, "%s_bucket{phase=%q,le=%q} %d\n", name, phase, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(&buf, "%s_bucket{phase=%q,le=\"+Inf\"} %d\n", name, phase, h.count)
+		fmt.Fprintf(&buf, "%s_sum{phase=%q} %s\n", name, phase, formatFloat(h.sum))
+		fmt.Fprintf(&buf, "%s_count{phase=%q} %d\n", name, phase, h.count)
+	}
+	s.metrics.mu.Unlock()
+
+	gauge := func(name string, help string, value float64) {
+		writeMetric(&buf, name, "gauge", help)
+		fmt.Fprintf(&buf, "%s %s\n", name, formatFloat(value))
+	}
+	boolean := func(b bool) float64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	gauge("archiver_paused", "Whether the task is paused.", boolean(paused))
+	gauge("archiver_throttled", "Whether the task is waiting for the replicas or the source load.", boolean(throttle.State != ""))
+	gauge("archiver_rows_estimated", "Rows estimated to be archived.", float64(c.Estimated))
+	gauge("archiver_memory_bytes", "Bytes of allocated heap objects.", float64(memStats.HeapAlloc))
+
+	if len(lags) != 0 {
+		addresses := make([]string, 0, len(lags))
+		for address := range lags {
+			addresses = append(addresses, address)
+		}
+		sort.Strings(addresses)
+		name = "archiver_replica_lag_seconds"
+		writeMetric(&buf, name, "gauge", "Replication lag last measured, -1 when the replication is not running.")
+		for _, address := range addresses {
+			fmt.Fprintf(&buf, "%s{replica=%q} %s\n", name, address, formatFloat(lags[address]))
+		}
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,90 @@
+package biz
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/dbadylan/go-mysql-archiver/internal/config"
+	"github.com/dbadylan/go-mysql-archiver/internal/data"
+)
+
+var strategies = map[int]string{
+	1: "page through the non-nullable unique key, and delete the rows by their key values",
+	2: "page through the key chosen by the optimizer, and delete the same key range with ORDER BY and LIMIT",
+	3: "no usable key, fetch the rows by a full scan, and delete them by the values of every column",
+}
+
+// printPlan
+//  print what the task would do without running it
+func printPlan(ctx context.Context, cfg *config.Config, srcDB *sql.DB, analysis data.Analysis) (err error) {
+	templateParam := &data.TemplateParam{
+		DB:          srcDB,
+		Table:       cfg.Source.Table,
+		Where:       cfg.Source.Where,
+		Limit:       cfg.Source.Limit,
+		Analysis:    analysis,
+		TargetTable: cfg.Target.Table,
+	}
+	templates, e := data.QueryTemplates(ctx, templateParam)
+	if e != nil {
+		err = e
+		return
+	}
+
+	mode := "archive"
+	switch {
+	case cfg.Purge:
+		mode = "purge"
+	case cfg.NoDelete:
+		mode = "copy without deleting"
+	}
+	target := fmt.Sprintf("%s/%s.%s", cfg.Target.Address, cfg.Target.Database, cfg.Target.Table)
+	switch {
+	case cfg.Purge:
+		target = "none"
+	case cfg.Target.Dir != "":
+		target = fmt.Sprintf("%s files in %s, compression %s", cfg.Target.Format, cfg.Target.Dir, cfg.Target.Compress)
+	}
+	keys := "none"
+	if len(analysis.Columns) != 0 {
+		keys = strings.Join(analysis.Columns, ", ")
+	}
+
+	fmt.Printf("source:     %s/%s.%s\n", cfg.Source.Address, cfg.Source.Database, cfg.Source.Table)
+	fmt.Printf("target:     %s\n", target)
+	fmt.Printf("mode:       %s\n", mode)
+	fmt.Printf("strategy:   %d, %s\n", analysis.QueryType, strategies[analysis.QueryType])
+	fmt.Printf("key:        %s\n", keys)
+	fmt.Printf("estimated:  %d rows, %d batches of %d\n", analysis.RowsEstimated, (analysis.RowsEstimated+cfg.Source.Limit-1)/cfg.Source.Limit, cfg.Source.Limit)
+	fmt.Println()
+
+	fmt.Println("-- select, first batch")
+	fmt.Println(templates.FirstSelect + ";")
+	if templates.NextSelect != templates.FirstSelect {
+		fmt.Println("-- select, following batches, ? is the key of the last row fetched")
+		fmt.Println(templates.NextSelect + ";")
+	}
+	switch {
+	case cfg.Purge:
+	case cfg.Target.Dir != "":
+		fmt.Println("-- the rows are written to the local files")
+	default:
+		fmt.Println("-- insert, on the target")
+		fmt.Println(templates.Insert + ";")
+	}
+	if cfg.NoDelete {
+		return
+	}
+	if templates.NextDelete == templates.FirstDelete {
+		fmt.Println("-- delete, on the source")
+		fmt.Println(templates.FirstDelete + ";")
+		return
+	}
+	fmt.Println("-- delete, first batch, on the source")
+	fmt.Println(templates.FirstDelete + ";")
+	fmt.Println("-- delete, following batches, on the source")
+	fmt.Println(templates.NextDelete + ";")
+	return
+}
@@ -0,0 +1,239 @@
+package biz
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// counters
+//  what the task has done so far
+type counters struct {
+	Batches int64
+	Select  int64
+	Insert  int64
+	Delete  int64
+	Retry   int64
+	Errors  int64
+	// Estimated is the rows estimated to be archived in total, it is refreshed by estimate-interval
+	Estimated int64
+	// Cursor is the key of the last row committed, empty when the table is not paged through a key
+	Cursor string
+}
+
+// archived
+//  the rows archived so far, which the percentage and the estimate are taken of: the rows deleted, or the rows
+//  copied when nothing is deleted
+func (c counters) archived(noDelete bool) int64 {
+	if noDelete {
+		return c.Insert
+	}
+	return c.Delete
+}
+
+// the states of a table
+const (
+	tablePending = "pending"
+	tableRunning = "running"
+	tableDone    = "done"
+	tableStopped = "stopped"
+	tableFailed  = "failed"
+)
+
+// progress
+//  the counters of a table, shared with the progress printer and the control socket
+type progress struct {
+	mu       sync.Mutex
+	counters counters
+	cursor   [][]byte
+	state    string
+	err      error
+}
+
+func (p *progress) add(result batchResult, cursor [][]byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.counters.Batches++
+	p.counters.Select += result.rows
+	p.counters.Insert += result.inserts
+	p.counters.Delete += result.deletes
+	if len(cursor) != 0 {
+		p.counters.Cursor = formatKey(cursor)
+		p.cursor = cursor
+	}
+}
+
+func (p *progress) addDelete(rows int64) {
+	p.mu.Lock()
+	p.counters.Delete += rows
+	p.mu.Unlock()
+}
+
+func (p *progress) retry() {
+	p.mu.Lock()
+	p.counters.Retry++
+	p.mu.Unlock()
+}
+
+func (p *progress) fail() {
+	p.mu.Lock()
+	p.counters.Errors++
+	p.mu.Unlock()
+}
+
+func (p *progress) position() [][]byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cursor
+}
+
+func (p *progress) setEstimated(rows int64) {
+	p.mu.Lock()
+	p.counters.Estimated = rows
+	p.mu.Unlock()
+}
+
+func (p *progress) snapshot() counters {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.counters
+}
+
+// setState
+//  err is the error the table has failed with
+func (p *progress) setState(state string, err error) {
+	p.mu.Lock()
+	p.state, p.err = state, err
+	p.mu.Unlock()
+}
+
+func (p *progress) status() (state string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state == "" {
+		return tablePending, nil
+	}
+	return p.state, p.err
+}
+
+// total
+//  the counters of every table added up, the cursor is only kept when there is a single table
+func total(tasks []*task) (c counters) {
+	for _, t := range tasks {
+		tc := t.progress.snapshot()
+		c.Batches += tc.Batches
+		c.Select += tc.Select
+		c.Insert += tc.Insert
+		c.Delete += tc.Delete
+		c.Retry += tc.Retry
+		c.Errors += tc.Errors
+		c.Estimated += tc.Estimated
+		if len(tasks) == 1 {
+			c.Cursor = tc.Cursor
+		}
+	}
+	return
+}
+
+// finished
+//  the number of tables that are done, stopped or failed
+func finished(tasks []*task) (n int) {
+	for _, t := range tasks {
+		if state, _ := t.progress.status(); state != tablePending && state != tableRunning {
+			n++
+		}
+	}
+	return
+}
+
+// tableStatus
+//  the status of a table of a multi-table task
+type tableStatus struct {
+	Table string `json:"table"`
+	// State is one of pending, running, done, stopped and failed
+	State     string `json:"state"`
+	Batch     int64  `json:"batch"`
+	Cursor    string `json:"cursor"`
+	Estimated int64  `json:"estimated"`
+	Rows      struct {
+		Select int64 `json:"select"`
+		Insert int64 `json:"insert"`
+		Delete int64 `json:"delete"`
+	} `json:"rows"`
+	Retry int64  `json:"retry"`
+	Error string `json:"error,omitempty"`
+}
+
+func tableStatuses(tasks []*task) (statuses []tableStatus) {
+	for _, t := range tasks {
+		c := t.progress.snapshot()
+		state, err := t.progress.status()
+		status := tableStatus{
+			Table:     t.cfg.Source.Table,
+			State:     state,
+			Batch:     c.Batches,
+			Cursor:    c.Cursor,
+			Estimated: c.Estimated,
+			Retry:     c.Retry,
+		}
+		status.Rows.Select, status.Rows.Insert, status.Rows.Delete = c.Select, c.Insert, c.Delete
+		if err != nil {
+			status.Error = err.Error()
+		}
+		statuses = append(statuses, status)
+	}
+	return
+}
+
+// rateWindow
+//  the time constant of the moving average of the rate
+const rateWindow = time.Minute
+
+// rate
+//  the rows per second since the last update, and its exponential moving average
+type rate struct {
+	rows    int64
+	at      time.Time
+	instant float64
+	average float64
+}
+
+func (r *rate) update(rows int64, now time.Time) {
+	if r.at.IsZero() {
+		r.rows, r.at = rows, now
+		return
+	}
+	elapsed := now.Sub(r.at).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	r.instant = float64(rows-r.rows) / elapsed
+	if r.average == 0 {
+		r.average = r.instant
+	} else {
+		alpha := 1 - math.Exp(-elapsed/rateWindow.Seconds())
+		r.average += alpha * (r.instant - r.average)
+	}
+	r.rows, r.at = rows, now
+}
+
+// eta
+//  the time left at the average rate, ok is false while the rate is unknown
+func (r *rate) eta(rows int64, estimated int64) (left time.Duration, ok bool) {
+	if r.average <= 0 {
+		return
+	}
+	remaining := estimated - rows
+	if remaining < 0 {
+		remaining = 0
+	}
+	left, ok = time.Duration(float64(remaining)/r.average*float64(time.Second)).Truncate(time.Second), true
+	return
+}
+
+func percent(rows int64, estimated int64) float64 {
+	if estimated <= 0 {
+		return 0
+	}
+	return float64(rows) * 100 / float64(estimated)
+}
@@ -0,0 +1,112 @@
+package biz
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/dbadylan/go-mysql-archiver/internal/data"
+	"github.com/dbadylan/go-mysql-archiver/internal/logger"
+)
+
+type chunk struct {
+	first [][]byte
+	last  [][]byte
+	// keys are the unique keys of the rows archived, one after another, they are dropped once the chunk has been
+	// checksummed in the target
+	keys    [][]byte
+	source  data.Checksum
+	target  data.Checksum
+	checked bool
+}
+
+// reconciler
+//  collect the checksums and the keys of the archived rows, consecutive batches are merged into chunks of at
+//  least size rows. Only the archived keys are checksummed in the target, so that the rows already in the target
+//  range do not count, and a chunk is checksummed as soon as it is full, so that its keys are not kept till the end
+type reconciler struct {
+	size     int64
+	tgtDB    *sql.DB
+	table    string
+	analysis data.Analysis
+	columns  []string
+	chunks   []*chunk
+}
+
+func newReconciler(size int64, tgtDB *sql.DB, table string, analysis data.Analysis) *reconciler {
+	return &reconciler{size: size, tgtDB: tgtDB, table: table, analysis: analysis}
+}
+
+// add
+//  keys are the unique keys of the rows of a batch, the first of them is where the batch starts. The batch has
+//  been committed, so the chunk can be checksummed in the target once it is full
+func (r *reconciler) add(ctx context.Context, columns []string, keys [][]byte, last [][]byte, checksum data.Checksum) {
+	if r.columns == nil {
+		r.columns = columns
+	}
+	var c *chunk
+	if n := len(r.chunks); n != 0 && r.chunks[n-1].source.Rows < r.size {
+		c = r.chunks[n-1]
+		c.last = last
+		c.keys = append(c.keys, keys...)
+		c.source.Rows += checksum.Rows
+		c.source.CRC ^= checksum.CRC
+	} else {
+		// first is copied, so that the keys of the batch are not held by it
+		first := append([][]byte(nil), keys[:len(r.analysis.Columns)]...)
+		c = &chunk{first: first, last: last, keys: append([][]byte(nil), keys...), source: checksum}
+		r.chunks = append(r.chunks, c)
+	}
+	if c.source.Rows < r.size {
+		return
+	}
+	if err := r.check(ctx, c); err != nil {
+		logger.Warn("reconcile: failed to checksum the chunk in the target, it is checksummed again after the run", "chunk", len(r.chunks), "error", err)
+	}
+}
+
+// check
+//  checksum a chunk in the target by its keys, which are kept for report to try again when it fails
+func (r *reconciler) check(ctx context.Context, c *chunk) (err error) {
+	where, args := data.KeysClause(r.analysis, c.keys)
+	if c.target, err = data.ChecksumRows(ctx, r.tgtDB, r.table, r.columns, where, args); err != nil {
+		return
+	}
+	c.keys = nil
+	c.checked = true
+	return
+}
+
+func formatKey(key [][]byte) string {
+	values := make([]string, len(key))
+	for i, value := range key {
+		values[i] = string(value)
+	}
+	return "(" + strings.Join(values, ", ") + ")"
+}
+
+// report
+//  checksum the chunks left, which are the last one and those failed to be checksummed during the run, and print
+//  whether every chunk matches the source
+func (r *reconciler) report(ctx context.Context) (err error) {
+	fmt.Printf("%-8s %-40s %12s %12s %16s %16s %s\n", "chunk", "range", "source_rows", "target_rows", "source_crc", "target_crc", "result")
+	var failed int
+	for i, c := range r.chunks {
+		if !c.checked {
+			if err = r.check(ctx, c); err != nil {
+				return
+			}
+		}
+		result := "pass"
+		if c.target != c.source {
+			result = "FAIL"
+			failed++
+		}
+		fmt.Printf("%-8d %-40s %12d %12d %16x %16x %s\n", i+1, formatKey(c.first)+" - "+formatKey(c.last), c.source.Rows, c.target.Rows, c.source.CRC, c.target.CRC, result)
+	}
+	if failed != 0 {
+		err = fmt.Errorf("reconcile: %d of %d chunks differ between the source and the target", failed, len(r.chunks))
+	}
+	return
+}
@@ -0,0 +1,225 @@
+package biz
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dbadylan/go-mysql-archiver/internal/config"
+	"github.com/dbadylan/go-mysql-archiver/internal/logger"
+)
+
+// taskStatus
+//  the answer to the status command
+type taskStatus struct {
+	// State is one of running, paused, throttled and stopping
+	State     string `json:"state"`
+	Batch     int64  `json:"batch"`
+	Cursor    string `json:"cursor"`
+	Estimated int64  `json:"estimated"`
+	Rows      struct {
+		Select int64 `json:"select"`
+		Insert int64 `json:"insert"`
+		Delete int64 `json:"delete"`
+	} `json:"rows"`
+	Retry    int64 `json:"retry"`
+	Throttle struct {
+		State string `json:"state"`
+		// ReplicaLagWait and SourceLoadWait are the time spent waiting so far
+		ReplicaLagWait string `json:"replica_lag_wait"`
+		SourceLoadWait string `json:"source_load_wait"`
+		// ReplicaLags are the lags in seconds last measured by replica, -1 when the replication is not running
+		ReplicaLags map[string]float64 `json:"replica_lags,omitempty"`
+		// SourceLoads are the status variables of max-load and critical-load last read from the source
+		SourceLoads map[string]int64 `json:"source_loads,omitempty"`
+	} `json:"throttle"`
+	Parameters struct {
+		Limit   int64  `json:"limit"`
+		Sleep   string `json:"sleep"`
+		MaxLag  string `json:"max_lag"`
+		MaxLoad string `json:"max_load"`
+	} `json:"parameters"`
+	// Tables are only listed when the task archives several tables, the counters above add them up
+	Tables []tableStatus `json:"tables,omitempty"`
+}
+
+// server
+//  answer the commands sent to the control socket and the HTTP API, for every table of the task
+type server struct {
+	tasks    []*task
+	control  *control
+	metrics  *metrics
+	throttle *throttler
+}
+
+func formatThresholds(thresholds []config.Threshold) string {
+	entries := make([]string, len(thresholds))
+	for i, threshold := range thresholds {
+		entries[i] = fmt.Sprintf("%s=%d", threshold.Name, threshold.Value)
+	}
+	return strings.Join(entries, ",")
+}
+
+func (s *server) status() (status taskStatus) {
+	c := total(s.tasks)
+	throttle := s.throttle.snapshot()
+	maxLag, maxLoad := s.throttle.limits()
+
+	status.State = state(s.control, s.throttle)
+	status.Batch = c.Batches
+	status.Cursor = c.Cursor
+	status.Estimated = c.Estimated
+	status.Rows.Select = c.Select
+	status.Rows.Insert = c.Insert
+	status.Rows.Delete = c.Delete
+	status.Retry = c.Retry
+	status.Throttle.State = throttle.State
+	status.Throttle.ReplicaLagWait = throttle.LagWait.Truncate(time.Second).String()
+	status.Throttle.SourceLoadWait = throttle.LoadWait.Truncate(time.Second).String()
+	status.Throttle.ReplicaLags = s.throttle.replicaLags()
+	status.Throttle.SourceLoads = s.throttle.sourceLoads()
+	status.Parameters.Limit = s.control.limit()
+	status.Parameters.Sleep = s.control.sleep().String()
+	status.Parameters.MaxLag = maxLag.String()
+	status.Parameters.MaxLoad = formatThresholds(maxLoad)
+	if len(s.tasks) > 1 {
+		status.Tables = tableStatuses(s.tasks)
+	}
+	return
+}
+
+// parameter
+//  parse the new value of a parameter, and return a func that applies it from the next batch
+func (s *server) parameter(name string, value string) (apply func() string, err error) {
+	switch name {
+	case "limit":
+		var limit int64
+		if limit, err = strconv.ParseInt(value, 10, 64); err != nil || limit <= 0 {
+			err = fmt.Errorf("limit: %q is not an integer greater than 0", value)
+			return
+		}
+		apply = func() string {
+			s.control.setLimit(limit)
+			return fmt.Sprintf("limit has been set to %d", limit)
+		}
+	case "sleep":
+		var sleep time.Duration
+		if sleep, err = time.ParseDuration(value); err != nil || sleep < 0 || sleep > 0 && sleep < time.Millisecond {
+			err = fmt.Errorf("sleep: %q is not 0 or a duration greater than 1ms", value)
+			return
+		}
+		apply = func() string {
+			s.control.setSleep(sleep)
+			return fmt.Sprintf("sleep has been set to %s", sleep)
+		}
+	case "max-lag":
+		var maxLag time.Duration
+		if maxLag, err = time.ParseDuration(value); err != nil || maxLag <= 0 {
+			err = fmt.Errorf("max-lag: %q is not a duration greater than 0", value)
+			return
+		}
+		apply = func() string {
+			s.throttle.setMaxLag(maxLag)
+			return fmt.Sprintf("max-lag has been set to %s", maxLag)
+		}
+	case "max-load":
+		var maxLoad []config.Threshold
+		if maxLoad, err = config.ParseThresholds("max-load", value); err != nil {
+			return
+		}
+		apply = func() string {
+			s.throttle.setMaxLoad(maxLoad)
+			return fmt.Sprintf("max-load has been set to %q", formatThresholds(maxLoad))
+		}
+	default:
+		err = fmt.Errorf("unknown parameter %q, it should be one of limit, sleep, max-lag and max-load", name)
+	}
+	return
+}
+
+func (s *server) set(name string, value string) (response string, err error) {
+	var apply func() string
+	if apply, err = s.parameter(name, value); err != nil {
+		return
+	}
+	response = apply()
+	return
+}
+
+// handle
+//  run a command and return the response without the trailing newline
+func (s *server) handle(cmd string) string {
+	fields := strings.Fields(cmd)
+	if len(fields) == 0 {
+		return "unknown command"
+	}
+	switch fields[0] {
+	case "pause":
+		s.control.pause()
+		return "task has been paused"
+	case "resume":
+		s.control.wake()
+		return "task will be resumed"
+	case "stop":
+		s.control.stop()
+		return "task will stop after the current batch"
+	case "cancel":
+		s.control.abort()
+		return "task has been stopped, the current batch is cancelled and rolled back"
+	case "status":
+		content, err := json.Marshal(s.status())
+		if err != nil {
+			return err.Error()
+		}
+		return string(content)
+	case "set":
+		if len(fields) < 3 {
+			return "usage: set <limit|sleep|max-lag|max-load> <value>"
+		}
+		response, err := s.set(fields[1], strings.Join(fields[2:], ""))
+		if err != nil {
+			return err.Error()
+		}
+		return response
+	default:
+		return "unknown command"
+	}
+}
+
+// socketTimeout
+//  how long a client of the control socket has to send its command and read the response
+const socketTimeout = 10 * time.Second
+
+// serve
+//  read one command per connection and write back the response, every connection is handled on its own so that
+//  an idle client does not hold the others back
+func (s *server) serve(listener net.Listener) {
+	for {
+		conn, e1 := listener.Accept()
+		if e1 != nil {
+			return
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *server) serveConn(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	if err := conn.SetDeadline(time.Now().Add(socketTimeout)); err != nil {
+		logger.Warn("control socket", "error", err)
+		return
+	}
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		logger.Warn("control socket", "error", err)
+		return
+	}
+	response := s.handle(strings.TrimSpace(string(buf[:n])))
+	if _, err = conn.Write([]byte(response + "\n")); err != nil {
+		logger.Warn("control socket", "error", err)
+	}
+}
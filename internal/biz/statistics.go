@@ -0,0 +1,230 @@
+package biz
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dbadylan/go-mysql-archiver/internal/config"
+	"github.com/dbadylan/go-mysql-archiver/internal/logger"
+)
+
+const (
+	ExitSuccess = 0
+	ExitFailure = 1
+	// ExitInterrupted is added to the number of the signal that stopped the task, like a shell does
+	ExitInterrupted = 128
+)
+
+// InterruptedError
+//  the task was stopped by a signal, every committed batch is consistent
+type InterruptedError struct {
+	Signal os.Signal
+}
+
+func (e *InterruptedError) Error() string {
+	return fmt.Sprintf("the task was interrupted by signal %s", e.Signal)
+}
+
+// ExitCode
+//  the exit status of the process for the error returned by Run, 130 for SIGINT and 143 for SIGTERM
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitSuccess
+	}
+	var interrupted *InterruptedError
+	if errors.As(err, &interrupted) {
+		if sig, ok := interrupted.Signal.(syscall.Signal); ok {
+			return ExitInterrupted + int(sig)
+		}
+		return ExitInterrupted
+	}
+	return ExitFailure
+}
+
+type instance struct {
+	Address  string `json:"address"`
+	Database string `json:"database"`
+	Table    string `json:"table"`
+	Charset  string `json:"charset"`
+}
+
+// actionTiming
+//  a line of the table printed by printActions
+type actionTiming struct {
+	Action  string  `json:"action"`
+	Count   int64   `json:"count"`
+	Seconds float64 `json:"seconds"`
+	Pct     float64 `json:"pct"`
+}
+
+// statistics
+//  what is printed after the task has finished and written to the statistics file
+type statistics struct {
+	Time struct {
+		Begin    string `json:"begin"`
+		Finish   string `json:"finish"`
+		Duration string `json:"duration"`
+	} `json:"time"`
+	Instance struct {
+		Source instance `json:"source"`
+		Target instance `json:"target"`
+	} `json:"instance"`
+	Action struct {
+		Select int64 `json:"select"`
+		Insert int64 `json:"insert"`
+		Delete int64 `json:"delete"`
+		Retry  int64 `json:"retry"`
+	} `json:"action"`
+	Estimated int64 `json:"estimated"`
+	// Throttle holds the time spent waiting for the replicas and the source load
+	Throttle struct {
+		ReplicaLagWait string `json:"replica_lag_wait"`
+		SourceLoadWait string `json:"source_load_wait"`
+	} `json:"throttle"`
+	// Concurrency is the number of tables archived at the same time, the pct of the timing is of the wall time
+	// multiplied by it
+	Concurrency int            `json:"concurrency"`
+	Timing      []actionTiming `json:"timing"`
+	// Tables are only listed when the task archives several tables
+	Tables     []tableStatus `json:"tables,omitempty"`
+	ExitStatus int           `json:"exit_status"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// newStatistics
+//  gather the statistics of a task, tasks and throttle are empty when the task has failed before they were created
+func newStatistics(cfg *config.Config, sTime time.Time, eTime time.Time, tasks []*task, throttle *throttler, m *metrics) (s *statistics) {
+	s = new(statistics)
+	s.Time.Begin = sTime.Format(config.TimeFormat)
+	s.Time.Finish = eTime.Format(config.TimeFormat)
+	s.Time.Duration = eTime.Sub(sTime).Truncate(time.Second).String()
+
+	srcTable, tgtTable := cfg.Source.Table, cfg.Target.Table
+	if cfg.MultiTable() {
+		// the tables are named after the source ones
+		names := make([]string, len(tasks))
+		for i, t := range tasks {
+			names[i] = t.cfg.Source.Table
+		}
+		srcTable = strings.Join(names, ",")
+		tgtTable = srcTable
+	}
+	s.Instance.Source = instance{
+		Address:  cfg.Source.Address,
+		Database: cfg.Source.Database,
+		Table:    srcTable,
+		Charset:  cfg.Source.Charset,
+	}
+	switch {
+	case cfg.Purge:
+	case cfg.Target.Dir != "":
+		s.Instance.Target = instance{Address: cfg.Target.Dir, Database: cfg.Target.Database, Table: tgtTable, Charset: cfg.Target.Charset}
+	default:
+		s.Instance.Target = instance{Address: cfg.Target.Address, Database: cfg.Target.Database, Table: tgtTable, Charset: cfg.Target.Charset}
+	}
+
+	s.Throttle.ReplicaLagWait, s.Throttle.SourceLoadWait = "0s", "0s"
+	if throttle != nil {
+		status := throttle.snapshot()
+		s.Throttle.ReplicaLagWait = status.LagWait.Truncate(time.Second).String()
+		s.Throttle.SourceLoadWait = status.LoadWait.Truncate(time.Second).String()
+	}
+	c := total(tasks)
+	s.Action.Select, s.Action.Insert, s.Action.Delete, s.Action.Retry = c.Select, c.Insert, c.Delete, c.Retry
+	s.Estimated = c.Estimated
+	var timings map[string]action
+	if m != nil {
+		timings = m.timings()
+	}
+	s.Concurrency = cfg.Concurrency
+	if s.Concurrency > len(tasks) {
+		s.Concurrency = len(tasks)
+	}
+	if s.Concurrency < 1 {
+		s.Concurrency = 1
+	}
+	s.Timing = actionTimings(timings, eTime.Sub(sTime)*time.Duration(s.Concurrency))
+	if len(tasks) > 1 {
+		s.Tables = tableStatuses(tasks)
+	}
+	return
+}
+
+// finish
+//  record how the task has ended
+func (s *statistics) finish(err error) {
+	s.ExitStatus = ExitCode(err)
+	s.Error = ""
+	if err != nil {
+		s.Error = err.Error()
+	}
+}
+
+func (s *statistics) print() {
+	content, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		logger.Error("statistics", "error", err)
+		return
+	}
+	fmt.Printf("\n%s\n\n", content)
+	printActions(s.Timing, s.Concurrency)
+}
+
+// writeStatistics
+//  replace the statistics file atomically
+func writeStatistics(file string, s *statistics) (err error) {
+	var content []byte
+	if content, err = json.MarshalIndent(s, "", "    "); err != nil {
+		return
+	}
+	tmpFile := file + ".tmp"
+	if err = os.WriteFile(tmpFile, append(content, '\n'), 0644); err != nil {
+		return
+	}
+	err = os.Rename(tmpFile, file)
+	return
+}
+
+// actionTimings
+//  the count, the total time and the percentage of the time of every action, elapsed is the wall time multiplied
+//  by the number of tables archived at the same time. Insert and delete run at the same time, so only their wall
+//  time, insert+delete, is taken out of other
+func actionTimings(timings map[string]action, elapsed time.Duration) (lines []actionTiming) {
+	other := elapsed
+	pct := func(d time.Duration) float64 {
+		if elapsed <= 0 {
+			return 0
+		}
+		return float64(d) * 100 / float64(elapsed)
+	}
+	for _, name := range actions {
+		a := timings[name]
+		if name != phaseInsert && name != phaseDelete {
+			other -= a.total
+		}
+		lines = append(lines, actionTiming{Action: name, Count: a.count, Seconds: a.total.Seconds(), Pct: pct(a.total)})
+	}
+	if other < 0 {
+		other = 0
+	}
+	lines = append(lines, actionTiming{Action: "other", Seconds: other.Seconds(), Pct: pct(other)})
+	return
+}
+
+// printActions
+//  print the timings like the action table of pt-archiver
+func printActions(lines []actionTiming, concurrency int) {
+	if concurrency > 1 {
+		fmt.Printf("Pct is of the wall time x %d tables archived at the same time\n", concurrency)
+	}
+	fmt.Printf("%-14s %10s %12s %8s\n", "Action", "Count", "Time", "Pct")
+	for _, line := range lines {
+		fmt.Printf("%-14s %10d %12.4f %8.2f\n", line.Action, line.Count, line.Seconds, line.Pct)
+	}
+	fmt.Println("insert and delete overlap, insert+delete is the wall time of both")
+}
@@ -0,0 +1,287 @@
+package biz
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dbadylan/go-mysql-archiver/internal/config"
+	"github.com/dbadylan/go-mysql-archiver/internal/data"
+)
+
+type replica struct {
+	address string
+	db      *sql.DB
+}
+
+// throttler
+//  hold the task back between batches while the servers are too busy, mu also guards MaxLag and MaxLoad of
+//  cfg, which can be changed while the task is running
+type throttler struct {
+	cfg      *config.Config
+	srcDB    *sql.DB
+	control  *control
+	replicas []replica
+	// onThrottle is called once the state has changed
+	onThrottle func(state string)
+
+	// waiting lets the tables archived at the same time wait one after another
+	waiting sync.Mutex
+
+	mu     sync.Mutex
+	names  []string
+	status throttleStatus
+	// lags are the replication lags in seconds last measured by address, -1 when not running
+	lags map[string]float64
+	// loads are the status variables last read from the source, by their lower case names
+	loads map[string]int64
+}
+
+type throttleStatus struct {
+	// State is what the task is waiting for, empty when not throttled
+	State    string
+	LagWait  time.Duration
+	LoadWait time.Duration
+}
+
+func newThrottler(cfg *config.Config, srcDB *sql.DB, ctl *control, onThrottle func(state string)) (t *throttler, err error) {
+	t = &throttler{cfg: cfg, srcDB: srcDB, control: ctl, onThrottle: onThrottle, lags: make(map[string]float64)}
+	t.names = statusNames(cfg.MaxLoad, cfg.CriticalLoad)
+	for _, m := range cfg.Replicas {
+		var db *sql.DB
+		if db, err = data.NewDB(ctl.ctx, m); err != nil {
+			t.close()
+			err = fmt.Errorf("replica %s: %w", m.Address, err)
+			return
+		}
+		t.replicas = append(t.replicas, replica{address: m.Address, db: db})
+	}
+	return
+}
+
+// statusNames
+//  the distinct status variables of the thresholds
+func statusNames(thresholds ...[]config.Threshold) (names []string) {
+	seen := make(map[string]bool)
+	for _, list := range thresholds {
+		for _, threshold := range list {
+			if name := strings.ToLower(threshold.Name); !seen[name] {
+				seen[name] = true
+				names = append(names, threshold.Name)
+			}
+		}
+	}
+	return
+}
+
+func (t *throttler) close() {
+	for _, r := range t.replicas {
+		_ = r.db.Close()
+	}
+}
+
+// enabled
+//  whether there is anything to wait for
+func (t *throttler) enabled() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.replicas) != 0 || len(t.names) != 0
+}
+
+func (t *throttler) snapshot() throttleStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status
+}
+
+func (t *throttler) replicaLags() map[string]float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lags := make(map[string]float64, len(t.lags))
+	for address, lag := range t.lags {
+		lags[address] = lag
+	}
+	return lags
+}
+
+func (t *throttler) sourceLoads() map[string]int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	loads := make(map[string]int64, len(t.loads))
+	for name, value := range t.loads {
+		loads[name] = value
+	}
+	return loads
+}
+
+func (t *throttler) limits() (maxLag time.Duration, maxLoad []config.Threshold) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cfg.MaxLag, t.cfg.MaxLoad
+}
+
+func (t *throttler) setMaxLag(maxLag time.Duration) {
+	t.mu.Lock()
+	t.cfg.MaxLag = maxLag
+	t.mu.Unlock()
+}
+
+func (t *throttler) setMaxLoad(maxLoad []config.Threshold) {
+	t.mu.Lock()
+	t.cfg.MaxLoad = maxLoad
+	t.names = statusNames(maxLoad, t.cfg.CriticalLoad)
+	t.mu.Unlock()
+}
+
+func (t *throttler) setState(state string) {
+	t.mu.Lock()
+	changed := t.status.State != state
+	t.status.State = state
+	t.mu.Unlock()
+	if changed && t.onThrottle != nil {
+		t.onThrottle(state)
+	}
+}
+
+// laggingReplica
+//  return a description of the first replica that lags behind more than max-lag, or an empty string, the lag of
+//  every replica is measured
+func (t *throttler) laggingReplica() (desc string, err error) {
+	maxLag, _ := t.limits()
+	for _, r := range t.replicas {
+		ctx, cancel := t.control.statement()
+		lag, running, e := data.ReplicaLag(ctx, r.db, t.cfg.HeartbeatTable)
+		cancel()
+		if e != nil {
+			err = fmt.Errorf("replica %s: %w", r.address, e)
+			return
+		}
+		seconds := lag.Seconds()
+		if !running {
+			seconds = -1
+		}
+		t.mu.Lock()
+		t.lags[r.address] = seconds
+		t.mu.Unlock()
+		if desc != "" {
+			continue
+		}
+		if !running {
+			desc = fmt.Sprintf("replica %s is not running", r.address)
+			continue
+		}
+		if lag > maxLag {
+			desc = fmt.Sprintf("replica %s lags %s behind", r.address, lag.Truncate(time.Millisecond))
+		}
+	}
+	return
+}
+
+// wait
+//  block until the replicas have caught up and the load of the source is within max-load
+func (t *throttler) wait() (err error) {
+	t.waiting.Lock()
+	defer t.waiting.Unlock()
+	if err = t.waitReplicas(); err != nil {
+		return
+	}
+	err = t.waitLoad()
+	return
+}
+
+// waitReplicas
+//  block until every replica has caught up within max-lag, or the task is stopped
+func (t *throttler) waitReplicas() (err error) {
+	if len(t.replicas) == 0 {
+		return
+	}
+	var start time.Time
+	defer func() {
+		t.mu.Lock()
+		if !start.IsZero() {
+			t.status.LagWait += time.Since(start)
+		}
+		t.mu.Unlock()
+		t.setState("")
+	}()
+	for {
+		var desc string
+		if desc, err = t.laggingReplica(); err != nil || desc == "" {
+			return
+		}
+		if start.IsZero() {
+			start = time.Now()
+		}
+		t.setState(desc)
+		if !t.control.wait(t.cfg.CheckInterval) {
+			return
+		}
+	}
+}
+
+func exceeded(thresholds []config.Threshold, values map[string]int64, reached bool) (desc string) {
+	for _, threshold := range thresholds {
+		value := values[strings.ToLower(threshold.Name)]
+		if value > threshold.Value || reached && value == threshold.Value {
+			desc = fmt.Sprintf("%s=%d", threshold.Name, value)
+			return
+		}
+	}
+	return
+}
+
+// waitLoad
+//  block until every status variable of the source is within max-load or the task is stopped, fail once any
+//  reaches critical-load
+func (t *throttler) waitLoad() (err error) {
+	var start time.Time
+	defer func() {
+		t.mu.Lock()
+		if !start.IsZero() {
+			t.status.LoadWait += time.Since(start)
+		}
+		t.mu.Unlock()
+		t.setState("")
+	}()
+	for {
+		t.mu.Lock()
+		names, maxLoad := t.names, t.cfg.MaxLoad
+		t.mu.Unlock()
+		if len(names) == 0 {
+			return
+		}
+		var values map[string]int64
+		ctx, cancel := t.control.statement()
+		values, err = data.GlobalStatus(ctx, t.srcDB, names)
+		cancel()
+		if err != nil {
+			return
+		}
+		t.mu.Lock()
+		t.loads = values
+		t.mu.Unlock()
+		for _, name := range names {
+			if _, ok := values[strings.ToLower(name)]; !ok {
+				err = fmt.Errorf("the status variable %s does not exist on the source", name)
+				return
+			}
+		}
+		if desc := exceeded(t.cfg.CriticalLoad, values, true); desc != "" {
+			err = fmt.Errorf("the source has reached the critical load(%s), abort", desc)
+			return
+		}
+		desc := exceeded(maxLoad, values, false)
+		if desc == "" {
+			return
+		}
+		if start.IsZero() {
+			start = time.Now()
+		}
+		t.setState("source load " + desc)
+		if !t.control.wait(t.cfg.CheckInterval) {
+			return
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package biz
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/crc32"
+	"time"
+
+	"github.com/dbadylan/go-mysql-archiver/internal/config"
+	"github.com/dbadylan/go-mysql-archiver/internal/data"
+	"github.com/dbadylan/go-mysql-archiver/internal/logger"
+)
+
+// xaPrefix
+//  the gtrid prefix of a task, so that recovering never touches the branches of other tasks
+func xaPrefix(cfg *config.Config) string {
+	task := fmt.Sprintf("%s/%s/%s->%s/%s/%s", cfg.Source.Address, cfg.Source.Database, cfg.Source.Table, cfg.Target.Address, cfg.Target.Database, cfg.Target.Table)
+	return fmt.Sprintf("go-mysql-archiver-%08x-", crc32.ChecksumIEEE([]byte(task)))
+}
+
+// recoverXA
+//  finish the branches left prepared by the last run. The target is always prepared before the source and
+//  committed before it, so a prepared source branch means the target branch has been prepared, and a
+//  prepared target branch alone means the source branch has never been prepared.
+func recoverXA(ctx context.Context, srcDB *sql.DB, tgtDB *sql.DB, prefix string) (err error) {
+	var srcGtrids, tgtGtrids []string
+	if srcGtrids, err = data.RecoverXA(ctx, srcDB, prefix, data.BranchSource); err != nil {
+		return
+	}
+	if tgtGtrids, err = data.RecoverXA(ctx, tgtDB, prefix, data.BranchTarget); err != nil {
+		return
+	}
+	srcPrepared := make(map[string]bool, len(srcGtrids))
+	for _, gtrid := range srcGtrids {
+		srcPrepared[gtrid] = true
+	}
+	for _, gtrid := range tgtGtrids {
+		if srcPrepared[gtrid] {
+			if err = data.CommitXA(ctx, tgtDB, gtrid, data.BranchTarget); err != nil {
+				return
+			}
+			logger.Info("xa: committed the prepared target branch", "gtrid", gtrid)
+			continue
+		}
+		if err = data.RollbackXA(ctx, tgtDB, gtrid, data.BranchTarget); err != nil {
+			return
+		}
+		logger.Info("xa: rolled back the prepared target branch", "gtrid", gtrid)
+	}
+	for _, gtrid := range srcGtrids {
+		if err = data.CommitXA(ctx, srcDB, gtrid, data.BranchSource); err != nil {
+			return
+		}
+		logger.Info("xa: committed the prepared source branch", "gtrid", gtrid)
+	}
+	return
+}
+
+type xaGenerator struct {
+	prefix string
+	start  int64
+	seq    int64
+}
+
+func newXAGenerator(cfg *config.Config) *xaGenerator {
+	return &xaGenerator{
+		prefix: xaPrefix(cfg),
+		start:  time.Now().Unix(),
+	}
+}
+
+func (g *xaGenerator) next() string {
+	g.seq++
+	return fmt.Sprintf("%s%d-%d", g.prefix, g.start, g.seq)
+}
+
+// commitXA
+//  two-phase commit of a batch, any branch left prepared on failure is finished by recoverXA on the next run
+func commitXA(srcXA data.XASourceTx, tgtXA data.XASinkTx, m *metrics) (err error) {
+	if err = m.time(actionPrepareTarget, tgtXA.Prepare); err != nil {
+		_ = tgtXA.Rollback()
+		_ = srcXA.Rollback()
+		return
+	}
+	if err = m.time(actionPrepareSource, srcXA.Prepare); err != nil {
+		// the target branch can only be rolled back once the source branch is known not to be prepared
+		if e := srcXA.Rollback(); e == nil {
+			_ = tgtXA.Rollback()
+		}
+		return
+	}
+	if err = m.time(actionCommitTarget, tgtXA.Commit); err != nil {
+		return
+	}
+	err = m.time(actionCommitSource, srcXA.Commit)
+	return
+}
@@ -3,41 +3,17 @@ package config
 import (
 	"errors"
 	"flag"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/dbadylan/go-mysql-archiver/internal/logger"
 )
 
 const TimeFormat = "2006-01-02 15:04:05"
 
-var StatisticsTemplate = `
-{
-    "time": {
-        "begin": "%s",
-        "finish": "%s",
-        "duration": "%s"
-    },
-    "instance": {
-        "source": {
-            "address": "%s",
-            "database": "%s",
-            "table": "%s",
-            "charset": "%s"
-        },
-        "target": {
-            "address": "%s",
-            "database": "%s",
-            "table": "%s",
-            "charset": "%s"
-        }
-    },
-    "action": {
-        "select": %d,
-        "insert": %d,
-        "delete": %d
-    }
-}
-
-`
-
 type MySQL struct {
 	Address  string
 	Username string
@@ -48,14 +24,30 @@ type MySQL struct {
 
 type Source struct {
 	MySQL
+	// Table is the table of the task, empty when the task archives several tables
 	Table string
-	Where string
-	Limit int64
+	// Tables are the tables listed by src-table, TableRegex chooses the tables of the database by name instead
+	Tables     []string
+	TableRegex string
+	Where      string
+	Limit      int64
 }
 
 type Target struct {
 	MySQL
 	Table string
+	// Dir is set when archiving to local files instead of a MySQL table
+	Dir      string
+	Format   string
+	Compress string
+	FileSize int64
+}
+
+// Threshold
+//  a limit on a global status variable, such as Threads_running=50
+type Threshold struct {
+	Name  string
+	Value int64
 }
 
 type Config struct {
@@ -67,6 +59,40 @@ type Config struct {
 	Memory     int64
 	RunTime    time.Duration
 	Socket     string
+	// HTTPListen is the address of the HTTP control API, empty means disable
+	HTTPListen string
+	Checkpoint string
+	Resume     bool
+	XA         bool
+	Purge      bool
+	NoDelete   bool
+	Verify     bool
+	// Reconcile compares the checksums of the archived key ranges between the source and the target after the run
+	Reconcile      bool
+	ReconcileChunk int64
+	// Replicas are checked between batches, the task waits while any of them lags behind more than MaxLag
+	Replicas       []MySQL
+	MaxLag         time.Duration
+	HeartbeatTable string
+	CheckInterval  time.Duration
+	// MaxLoad holds the task back while any variable is above its threshold, CriticalLoad aborts it
+	MaxLoad      []Threshold
+	CriticalLoad []Threshold
+	// Retries is the number of times a batch is retried after transient errors, the interval doubles every time
+	Retries       int
+	RetryInterval time.Duration
+	// QueryTimeout cancels a statement that has run longer, 0 means no limit
+	QueryTimeout time.Duration
+	// EstimateInterval is how often the rows left are estimated again, 0 means never
+	EstimateInterval time.Duration
+	EstimateCount    bool
+	// StatisticsFile receives the statistics in JSON however the task ends, empty means disable
+	StatisticsFile string
+	Log            logger.Options
+	// DryRun prints the plan and the statements of the task and exits without touching any row
+	DryRun bool
+	// Concurrency is the number of tables archived at the same time
+	Concurrency int
 }
 
 func NewFlag() (cfg *Config, err error) {
@@ -75,7 +101,8 @@ func NewFlag() (cfg *Config, err error) {
 	srcPassword := flag.String("src-password", "", "source mysql password")
 	srcDatabase := flag.String("src-database", "", "source database")
 	srcCharset := flag.String("src-charset", "utf8mb4", "source character set")
-	srcTable := flag.String("src-table", "", "source table")
+	srcTable := flag.String("src-table", "", "source table, or comma-separated source tables archived with the same where clause")
+	srcTableRegex := flag.String("src-table-regex", "", "archive the tables of the source database whose whole names match this regular expression, such as log_.* or .* for every table")
 	srcWhere := flag.String("src-where", "", "the WHERE clause, if unspecified, it will fetch all rows")
 	srcLimit := flag.Uint("src-limit", 500, "the number of rows fetched per round")
 
@@ -85,58 +112,70 @@ func NewFlag() (cfg *Config, err error) {
 	tgtDatabase := flag.String("tgt-database", "", "target database, if unspecified, it defaults to the source database")
 	tgtCharset := flag.String("tgt-charset", "", "target character set, if unspecified, it defaults to the source character set")
 	tgtTable := flag.String("tgt-table", "", "target table, if unspecified, it defaults to the source table")
+	tgtDir := flag.String("tgt-dir", "", "archive to local files in this directory instead of a MySQL table")
+	tgtFormat := flag.String("tgt-format", "csv", "format of the local files, one of csv, jsonl and sql")
+	tgtCompress := flag.String("tgt-compress", "none", "compression of the local files, one of none, gzip and zstd")
+	tgtFileSize := flag.Int64("tgt-file-size", 0, "rotate the local file once its uncompressed size in bytes has reached this value, 0 means never")
 
 	progress := flag.Duration("progress", 5*time.Second, "time interval for printing progress, such as 10s, 1m, etc, 0 means disable")
 	sleep := flag.Duration("sleep", 0, "time interval for fetching rows, such as 500ms, 1s, etc, if unspecified, it means disable")
 	statistics := flag.Bool("statistics", false, "print statistics after task has finished")
+	estimateInterval := flag.Duration("estimate-interval", 0, "time interval for estimating the rows left again, such as 5m, the estimate of EXPLAIN at startup is often far off, 0 means never")
+	estimateCount := flag.Bool("estimate-count", false, "estimate the rows left with SELECT COUNT(*) instead of EXPLAIN, it is exact but scans the rows")
+	statisticsFile := flag.String("statistics-file", "", "write the statistics in JSON to this file after the task has finished or failed, along with the exit status and the error")
 	memory := flag.Int64("memory", 0, "max memory usage in bytes, if unspecified, it means unlimited")
 	runTime := flag.Duration("run-time", 0, "time to run before exiting, such as 600s, 120m, 5h30m15s, etc")
 	socket := flag.String("socket", "", "unix socket file path")
+	httpListen := flag.String("http-listen", "", "address of the HTTP control and status API, such as 127.0.0.1:8080 or :8080, if unspecified, it means disable")
+	checkReplica := flag.String("check-replica", "", "comma-separated replicas to check for replication lag, each one is host:port or user:password@host:port, the credentials default to the source ones")
+	maxLag := flag.Duration("max-lag", time.Second, "pause archiving while the lag of any replica is larger than this value")
+	heartbeatTable := flag.String("heartbeat-table", "", "pt-heartbeat table to measure the lag with, such as percona.heartbeat, if unspecified, Seconds_Behind_Master is used")
+	maxLoad := flag.String("max-load", "", "comma-separated global status thresholds of the source, such as Threads_running=50,Innodb_row_lock_current_waits=10, pause archiving while any of them is exceeded")
+	criticalLoad := flag.String("critical-load", "", "comma-separated global status thresholds of the source in the same format as max-load, abort the task once any of them is reached")
+	checkInterval := flag.Duration("check-interval", time.Second, "time interval for checking again while throttled")
+	retries := flag.Int("retries", 3, "number of times to retry a batch that failed with transient errors, such as deadlocks, lock wait timeouts and lost connections")
+	retryInterval := flag.Duration("retry-interval", time.Second, "time to wait before the first retry, it doubles for each following retry, up to 1m")
+	queryTimeout := flag.Duration("query-timeout", 0, "cancel a statement that has run longer than this value, such as 30s, the batch is rolled back and retried like after a lock wait timeout, 0 means no limit")
+	checkpoint := flag.String("checkpoint", "", "checkpoint file path, if unspecified, it means disable")
+	purge := flag.Bool("purge", false, "delete the rows from the source without copying them anywhere, the target is ignored")
+	noDelete := flag.Bool("no-delete", false, "copy the rows to the target without deleting them from the source, the table must have a non-nullable unique key")
+	verify := flag.Bool("verify", false, "read every batch back from the target before committing, and abort if the CRC32 of any row differs from the source, the table must have a non-nullable unique key")
+	reconcile := flag.Bool("reconcile", false, "after the run, compare the row counts and checksums of the archived key ranges between the source and the target chunk by chunk, the table must have a non-nullable unique key")
+	reconcileChunk := flag.Int64("reconcile-chunk", 10000, "the minimum number of rows in a chunk compared by reconcile")
+	xa := flag.Bool("xa", false, "commit the source and the target with XA transactions, the branches left prepared by the last run are recovered at startup")
+	resume := flag.Bool("resume", false, "reconcile the batch left by the last run and carry on from the checkpoint, start from the beginning when the checkpoint file does not exist yet")
+	logLevel := flag.String("log-level", "info", "log level, one of debug, info, warn and error, debug prints a line for every batch")
+	logFormat := flag.String("log-format", "text", "log format, one of text and json")
+	logFile := flag.String("log-file", "", "log file path, if unspecified, logs are printed to stdout")
+	logMaxSize := flag.Int64("log-max-size", 100<<20, "rotate the log file once its size in bytes has reached this value, 0 means never")
+	logMaxBackups := flag.Int("log-max-backups", 5, "number of rotated log files kept, such as archiver.log.1, archiver.log.2, etc")
+	dryRun := flag.Bool("dry-run", false, "connect to the source, print the chosen strategy, the estimated rows and the statements that would be run, then exit without changing anything")
+	concurrency := flag.Int("concurrency", 1, "the number of tables archived at the same time when archiving several tables")
+
+	configFile := flag.String("config", "", "config file path, the keys are the flag names, such as src-password, in YAML, JSON or TOML format. Flags take precedence over environment variables(ARCHIVER_SRC_PASSWORD, etc), which take precedence over the file")
 
 	flag.Parse()
 
-	if *srcAddress == "" {
-		err = errors.New("the source address was specified with an empty value")
-		return
-	}
-	if *srcDatabase == "" {
-		err = errors.New("the source database was specified with an empty value")
-		return
-	}
-	if *tgtDatabase == "" {
-		tgtDatabase = srcDatabase
-	}
-	if *srcTable == "" {
-		err = errors.New("the source table was specified with an empty value")
+	if err = applySources(flag.CommandLine, *configFile); err != nil {
 		return
 	}
-	if *tgtTable == "" {
-		tgtTable = srcTable
-	}
-	if *srcAddress == *tgtAddress && *srcDatabase == *tgtDatabase && *srcTable == *tgtTable {
-		err = errors.New("the source and target tables are identical")
-		return
-	}
-	if *srcCharset == "" {
-		err = errors.New("the source charset was specified with an empty value")
-		return
-	}
-	if *tgtCharset == "" {
-		tgtCharset = srcCharset
-	}
-	if *srcLimit == 0 {
-		*srcLimit = 500
+
+	var replicas []MySQL
+	if *checkReplica != "" {
+		if replicas, err = parseReplicas(*checkReplica, MySQL{Username: *srcUsername, Password: *srcPassword, Charset: *srcCharset}); err != nil {
+			return
+		}
 	}
-	if *progress < time.Second {
-		err = errors.New("the value of progress must be equal to 0 or greater than 1s")
+	var maxLoadThresholds, criticalLoadThresholds []Threshold
+	if maxLoadThresholds, err = ParseThresholds("max-load", *maxLoad); err != nil {
 		return
 	}
-	if *sleep > 0 && *sleep < time.Millisecond {
-		err = errors.New("the value of sleep must be equal to 0 or greater than 100ms")
+	if criticalLoadThresholds, err = ParseThresholds("critical-load", *criticalLoad); err != nil {
 		return
 	}
-	if *memory < 0 {
-		err = errors.New("the value of memory cannot be less than 0")
+	level, e := logger.ParseLevel(*logLevel)
+	if e != nil {
+		err = fmt.Errorf("log-level: %w", e)
 		return
 	}
 	cfg = &Config{
@@ -148,9 +187,10 @@ func NewFlag() (cfg *Config, err error) {
 				Database: *srcDatabase,
 				Charset:  *srcCharset,
 			},
-			Table: *srcTable,
-			Where: *srcWhere,
-			Limit: int64(*srcLimit),
+			Table:      *srcTable,
+			TableRegex: *srcTableRegex,
+			Where:      *srcWhere,
+			Limit:      int64(*srcLimit),
 		},
 		Target: Target{
 			MySQL: MySQL{
@@ -160,7 +200,11 @@ func NewFlag() (cfg *Config, err error) {
 				Database: *tgtDatabase,
 				Charset:  *tgtCharset,
 			},
-			Table: *tgtTable,
+			Table:    *tgtTable,
+			Dir:      *tgtDir,
+			Format:   *tgtFormat,
+			Compress: *tgtCompress,
+			FileSize: *tgtFileSize,
 		},
 		Progress:   *progress,
 		Sleep:      *sleep,
@@ -168,7 +212,296 @@ func NewFlag() (cfg *Config, err error) {
 		Memory:     *memory,
 		RunTime:    *runTime,
 		Socket:     *socket,
+		HTTPListen: *httpListen,
+		Checkpoint: *checkpoint,
+		Resume:     *resume,
+		XA:         *xa,
+		Purge:      *purge,
+		NoDelete:   *noDelete,
+		Verify:     *verify,
+
+		Reconcile:      *reconcile,
+		ReconcileChunk: *reconcileChunk,
+
+		Replicas:       replicas,
+		MaxLag:         *maxLag,
+		HeartbeatTable: *heartbeatTable,
+		CheckInterval:  *checkInterval,
+		MaxLoad:        maxLoadThresholds,
+		CriticalLoad:   criticalLoadThresholds,
+
+		Retries:       *retries,
+		RetryInterval: *retryInterval,
+		QueryTimeout:  *queryTimeout,
+
+		EstimateInterval: *estimateInterval,
+		EstimateCount:    *estimateCount,
+
+		StatisticsFile: *statisticsFile,
+		Log: logger.Options{
+			Level:      level,
+			Format:     *logFormat,
+			File:       *logFile,
+			MaxSize:    *logMaxSize,
+			MaxBackups: *logMaxBackups,
+		},
+		DryRun:      *dryRun,
+		Concurrency: *concurrency,
+	}
+	if err = cfg.Validate(); err != nil {
+		cfg = nil
 	}
 
 	return
 }
+
+// Validate
+//  check the config and fill in the values that default to others, such as the target table to the source one,
+//  the errors are prefixed with the name of the flag
+func (cfg *Config) Validate() (err error) {
+	src, tgt := &cfg.Source, &cfg.Target
+	if src.Address == "" {
+		err = errors.New("src-address: the source address was specified with an empty value")
+		return
+	}
+	if src.Database == "" {
+		err = errors.New("src-database: the source database was specified with an empty value")
+		return
+	}
+	if tgt.Database == "" {
+		tgt.Database = src.Database
+	}
+	if strings.Contains(src.Table, ",") {
+		src.Tables = nil
+		for _, table := range strings.Split(src.Table, ",") {
+			if table = strings.TrimSpace(table); table != "" {
+				src.Tables = append(src.Tables, table)
+			}
+		}
+		src.Table = ""
+		if len(src.Tables) == 1 {
+			src.Table, src.Tables = src.Tables[0], nil
+		}
+	}
+	if src.TableRegex != "" {
+		if src.Table != "" || len(src.Tables) != 0 {
+			err = errors.New("src-table-regex: src-table-regex can not be used along with src-table")
+			return
+		}
+		if _, e := regexp.Compile(src.TableRegex); e != nil {
+			err = fmt.Errorf("src-table-regex: %w", e)
+			return
+		}
+	}
+	if src.Table == "" && len(src.Tables) == 0 && src.TableRegex == "" {
+		err = errors.New("src-table: the source table was specified with an empty value")
+		return
+	}
+	if cfg.MultiTable() && tgt.Table != "" {
+		err = errors.New("tgt-table: the target tables are named after the source ones when archiving several tables")
+		return
+	}
+	if tgt.Table == "" {
+		tgt.Table = src.Table
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.NoDelete {
+		if cfg.Purge {
+			err = errors.New("no-delete: there is nothing left to do when purging without deleting")
+			return
+		}
+		if cfg.XA {
+			err = errors.New("xa: XA transactions are not needed when nothing is deleted")
+			return
+		}
+	}
+	if cfg.Verify && (cfg.Purge || tgt.Dir != "") {
+		err = errors.New("verify: rows can only be verified in a MySQL target")
+		return
+	}
+	if cfg.Reconcile {
+		if cfg.Purge || tgt.Dir != "" {
+			err = errors.New("reconcile: rows can only be reconciled in a MySQL target")
+			return
+		}
+		if cfg.ReconcileChunk <= 0 {
+			err = errors.New("reconcile-chunk: the value of reconcile-chunk must be greater than 0")
+			return
+		}
+	}
+	if cfg.Purge {
+		if tgt.Dir != "" {
+			err = errors.New("tgt-dir: there is no target when purging")
+			return
+		}
+		if cfg.XA {
+			err = errors.New("xa: XA transactions are not available when purging")
+			return
+		}
+	} else if tgt.Dir != "" {
+		switch tgt.Format {
+		case "csv", "jsonl", "sql":
+		default:
+			err = errors.New("tgt-format: the format must be one of csv, jsonl and sql")
+			return
+		}
+		switch tgt.Compress {
+		case "none", "gzip", "zstd":
+		default:
+			err = errors.New("tgt-compress: the compression must be one of none, gzip and zstd")
+			return
+		}
+		if tgt.FileSize < 0 {
+			err = errors.New("tgt-file-size: the value of tgt-file-size cannot be less than 0")
+			return
+		}
+		if cfg.XA {
+			err = errors.New("xa: XA transactions are not available when archiving to local files")
+			return
+		}
+	} else if src.Address == tgt.Address && src.Database == tgt.Database && (cfg.MultiTable() || src.Table == tgt.Table) {
+		err = errors.New("tgt-table: the source and target tables are identical")
+		return
+	}
+	if src.Charset == "" {
+		err = errors.New("src-charset: the source charset was specified with an empty value")
+		return
+	}
+	if tgt.Charset == "" {
+		tgt.Charset = src.Charset
+	}
+	if src.Limit <= 0 {
+		src.Limit = 500
+	}
+	if cfg.Progress != 0 && cfg.Progress < time.Second {
+		err = errors.New("progress: the value of progress must be equal to 0 or greater than 1s")
+		return
+	}
+	if cfg.Sleep > 0 && cfg.Sleep < time.Millisecond {
+		err = errors.New("sleep: the value of sleep must be equal to 0 or greater than 100ms")
+		return
+	}
+	if cfg.EstimateInterval < 0 {
+		err = errors.New("estimate-interval: the value of estimate-interval cannot be less than 0")
+		return
+	}
+	if cfg.EstimateCount && cfg.EstimateInterval == 0 {
+		err = errors.New("estimate-count: estimate-count only takes effect along with estimate-interval")
+		return
+	}
+	if cfg.Memory < 0 {
+		err = errors.New("memory: the value of memory cannot be less than 0")
+		return
+	}
+	if len(cfg.Replicas) != 0 && cfg.MaxLag <= 0 {
+		err = errors.New("max-lag: the value of max-lag must be greater than 0")
+		return
+	}
+	if cfg.Retries < 0 {
+		err = errors.New("retries: the value of retries cannot be less than 0")
+		return
+	}
+	if cfg.RetryInterval <= 0 {
+		err = errors.New("retry-interval: the value of retry-interval must be greater than 0")
+		return
+	}
+	if cfg.QueryTimeout < 0 {
+		err = errors.New("query-timeout: the value of query-timeout cannot be less than 0")
+		return
+	}
+	if cfg.CheckInterval <= 0 {
+		err = errors.New("check-interval: the value of check-interval must be greater than 0")
+		return
+	}
+	switch cfg.Log.Format {
+	case logger.FormatText, logger.FormatJSON:
+	default:
+		err = errors.New("log-format: the format must be one of text and json")
+		return
+	}
+	if cfg.Log.MaxSize < 0 {
+		err = errors.New("log-max-size: the value of log-max-size cannot be less than 0")
+		return
+	}
+	if cfg.Log.MaxBackups < 0 {
+		err = errors.New("log-max-backups: the value of log-max-backups cannot be less than 0")
+		return
+	}
+	if cfg.Resume && cfg.Checkpoint == "" {
+		err = errors.New("resume: the checkpoint must be specified when resuming")
+		return
+	}
+	return
+}
+
+// MultiTable
+//  whether the task archives several tables, listed or chosen by src-table-regex
+func (cfg *Config) MultiTable() bool {
+	return len(cfg.Source.Tables) != 0 || cfg.Source.TableRegex != ""
+}
+
+// ForTable
+//  the config of one of the tables of a multi-table task, the target table and the checkpoint file are named
+//  after it
+func (cfg *Config) ForTable(table string) *Config {
+	c := *cfg
+	c.Source.Table, c.Source.Tables, c.Source.TableRegex = table, nil, ""
+	c.Target.Table = table
+	if c.Checkpoint != "" {
+		c.Checkpoint += "." + table
+	}
+	return &c
+}
+
+// parseReplicas
+//  parse host:port or user:password@host:port entries separated by commas
+func parseReplicas(value string, base MySQL) (replicas []MySQL, err error) {
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		replica := base
+		if i := strings.LastIndex(entry, "@"); i >= 0 {
+			credential := entry[:i]
+			entry = entry[i+1:]
+			replica.Username = credential
+			replica.Password = ""
+			if j := strings.Index(credential, ":"); j >= 0 {
+				replica.Username, replica.Password = credential[:j], credential[j+1:]
+			}
+		}
+		if entry == "" {
+			err = fmt.Errorf("check-replica: the address of %q was specified with an empty value", value)
+			return
+		}
+		replica.Address = entry
+		replicas = append(replicas, replica)
+	}
+	return
+}
+
+// ParseThresholds
+//  parse name=value entries separated by commas
+func ParseThresholds(key string, value string) (thresholds []Threshold, err error) {
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.Index(entry, "=")
+		if i <= 0 {
+			err = fmt.Errorf("%s: %q should be in the format of name=value", key, entry)
+			return
+		}
+		var threshold int64
+		if threshold, err = strconv.ParseInt(strings.TrimSpace(entry[i+1:]), 10, 64); err != nil {
+			err = fmt.Errorf("%s: the value of %q is not an integer", key, entry)
+			return
+		}
+		thresholds = append(thresholds, Threshold{Name: strings.TrimSpace(entry[:i]), Value: threshold})
+	}
+	return
+}
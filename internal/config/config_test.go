@@ -0,0 +1,53 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dbadylan/go-mysql-archiver/internal/logger"
+)
+
+// validConfig
+//  the smallest config that passes Validate
+func validConfig() *Config {
+	cfg := &Config{
+		RetryInterval: time.Second,
+		CheckInterval: time.Second,
+		Log:           logger.Options{Format: logger.FormatText},
+	}
+	cfg.Source.MySQL = MySQL{Address: "127.0.0.1:3306", Database: "db", Charset: "utf8mb4"}
+	cfg.Source.Table = "t1"
+	cfg.Target.MySQL = MySQL{Address: "127.0.0.1:3307"}
+	return cfg
+}
+
+func TestValidateEstimate(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		interval time.Duration
+		count    bool
+		// err is the prefix of the error, empty when the config is valid
+		err string
+	}{
+		{name: "never"},
+		{name: "explain", interval: 5 * time.Minute},
+		{name: "count", interval: 5 * time.Minute, count: true},
+		{name: "negative interval", interval: -time.Second, err: "estimate-interval:"},
+		{name: "count without interval", count: true, err: "estimate-count:"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := validConfig()
+			cfg.EstimateInterval, cfg.EstimateCount = c.interval, c.count
+			err := cfg.Validate()
+			switch {
+			case c.err == "" && err != nil:
+				t.Errorf("Validate: %v", err)
+			case c.err != "" && err == nil:
+				t.Errorf("Validate succeeded, want the error %s", c.err)
+			case c.err != "" && !strings.HasPrefix(err.Error(), c.err):
+				t.Errorf("got the error %q, want %s", err, c.err)
+			}
+		})
+	}
+}
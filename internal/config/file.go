@@ -0,0 +1,108 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix
+//  every flag can be set by an environment variable, such as ARCHIVER_SRC_PASSWORD for src-password
+const EnvPrefix = "ARCHIVER_"
+
+func envName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// readFile
+//  read a flat mapping from flag names to values, the format is chosen by the file extension
+func readFile(file string) (values map[string]interface{}, err error) {
+	var content []byte
+	if content, err = os.ReadFile(file); err != nil {
+		return
+	}
+	switch ext := strings.ToLower(filepath.Ext(file)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &values)
+	case ".json":
+		// the numbers are kept as written, a float64 would print 1000000 as 1e+06
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+		err = decoder.Decode(&values)
+	case ".toml":
+		err = toml.Unmarshal(content, &values)
+	default:
+		err = fmt.Errorf("unsupported config file format %q, it should be one of .yaml, .yml, .json and .toml", ext)
+	}
+	if err != nil {
+		err = fmt.Errorf("config file %s: %w", file, err)
+	}
+	return
+}
+
+// applySources
+//  fill the flags not given on the command line, environment variables take precedence over the config file
+func applySources(fs *flag.FlagSet, file string) (err error) {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	var values map[string]interface{}
+	if file != "" {
+		if values, err = readFile(file); err != nil {
+			return
+		}
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == "config" || fs.Lookup(key) == nil {
+			return fmt.Errorf("config file %s: unknown key %q", file, key)
+		}
+	}
+
+	var errs []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if explicit[f.Name] || f.Name == "config" {
+			return
+		}
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			if e := fs.Set(f.Name, value); e != nil {
+				errs = append(errs, fmt.Sprintf("environment variable %s: %s", envName(f.Name), e.Error()))
+			}
+			return
+		}
+		value, ok := values[f.Name]
+		if !ok {
+			return
+		}
+		switch value.(type) {
+		case map[string]interface{}, []interface{}, nil:
+			errs = append(errs, fmt.Sprintf("config file %s: key %q: a scalar value is expected", file, f.Name))
+			return
+		}
+		text := fmt.Sprint(value)
+		if number, ok := value.(float64); ok {
+			text = strconv.FormatFloat(number, 'f', -1, 64)
+		}
+		if e := fs.Set(f.Name, text); e != nil {
+			errs = append(errs, fmt.Sprintf("config file %s: key %q: %s", file, f.Name, e.Error()))
+		}
+	})
+	if len(errs) != 0 {
+		err = errors.New(strings.Join(errs, "\n"))
+	}
+	return
+}
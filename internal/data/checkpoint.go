@@ -0,0 +1,84 @@
+package data
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// PhasePrepared both statements of the batch have been executed, but nothing has been committed yet
+	PhasePrepared = "prepared"
+	// PhaseTargetCommitted the target has committed the batch, the source has not
+	PhaseTargetCommitted = "target-committed"
+	// PhaseCommitted both sides have committed the batch
+	PhaseCommitted = "committed"
+)
+
+// Checkpoint
+//  the progress of a task and the commit phase of the batch in flight
+type Checkpoint struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	// Where and Key are what the cursor means, a checkpoint is only resumed with the same ones
+	Where string   `json:"where"`
+	Key   []string `json:"key"`
+	Phase string   `json:"phase"`
+	// Cursor is the key of the last row of the last batch committed on both sides
+	Cursor [][]byte `json:"cursor"`
+	Batch  *Batch   `json:"batch,omitempty"`
+}
+
+// Batch
+//  the key range of a batch and the source DELETE that removes it
+type Batch struct {
+	Cursor [][]byte `json:"cursor"`
+	Where  string   `json:"where"`
+	Values [][]byte `json:"values"`
+	Rows   int64    `json:"rows"`
+}
+
+func LoadCheckpoint(file string) (checkpoint *Checkpoint, err error) {
+	var content []byte
+	if content, err = os.ReadFile(file); err != nil {
+		return
+	}
+	checkpoint = new(Checkpoint)
+	err = json.Unmarshal(content, checkpoint)
+	return
+}
+
+// SaveCheckpoint
+//  replace the checkpoint file atomically, the content is flushed to disk before returning
+func SaveCheckpoint(file string, checkpoint *Checkpoint) (err error) {
+	var content []byte
+	if content, err = json.Marshal(checkpoint); err != nil {
+		return
+	}
+	tmpFile := file + ".tmp"
+	var f *os.File
+	if f, err = os.OpenFile(tmpFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644); err != nil {
+		return
+	}
+	if _, err = f.Write(content); err != nil {
+		_ = f.Close()
+		return
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return
+	}
+	if err = f.Close(); err != nil {
+		return
+	}
+	if err = os.Rename(tmpFile, file); err != nil {
+		return
+	}
+	var dir *os.File
+	if dir, err = os.Open(filepath.Dir(file)); err != nil {
+		return
+	}
+	defer func() { _ = dir.Close() }()
+	err = dir.Sync()
+	return
+}
@@ -0,0 +1,78 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Queryer
+//  satisfied by *sql.DB and Tx
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+type Checksum struct {
+	Rows int64
+	CRC  uint64
+}
+
+// checksumExpr
+//  the per-row CRC32 of pt-table-checksum, the columns are converted to utf8mb4 first so that the same
+//  characters stored in different character sets produce the same checksum
+func checksumExpr(columns []string) string {
+	values := make([]string, len(columns))
+	nulls := make([]string, len(columns))
+	for i, column := range columns {
+		values[i] = fmt.Sprintf("CONVERT(`%s` USING utf8mb4)", column)
+		nulls[i] = fmt.Sprintf("ISNULL(`%s`)", column)
+	}
+	return fmt.Sprintf("COALESCE(BIT_XOR(CRC32(CONCAT_WS('#', %s, CONCAT(%s)))), 0)", strings.Join(values, ", "), strings.Join(nulls, ", "))
+}
+
+// ChecksumRows
+//  count the rows matching the condition and BIT_XOR their CRC32, checksums of disjoint rows can be combined
+//  by adding the counts and xor-ing the CRCs
+func ChecksumRows(ctx context.Context, q Queryer, table string, columns []string, where string, args []interface{}) (checksum Checksum, err error) {
+	query := fmt.Sprintf("SELECT /* go-mysql-archiver */ COUNT(*), %s FROM `%s`", checksumExpr(columns), table)
+	if where != "" {
+		query += " WHERE " + where
+	}
+	var rows *sql.Rows
+	if rows, err = q.QueryContext(ctx, query, args...); err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		if err = rows.Scan(&checksum.Rows, &checksum.CRC); err != nil {
+			return
+		}
+	}
+	err = rows.Err()
+	return
+}
+
+// KeysClause
+//  the condition of the rows whose unique key is one of the given key values, only available for QueryType 1
+func KeysClause(analysis Analysis, values [][]byte) (clause string, args []interface{}) {
+	colQty := len(analysis.Columns)
+	placeholders := make([]string, colQty)
+	for i := range placeholders {
+		placeholders[i] = "?"
+	}
+	tuple := "(" + strings.Join(placeholders, ", ") + ")"
+	tuples := make([]string, len(values)/colQty)
+	for i := range tuples {
+		tuples[i] = tuple
+	}
+	clause = fmt.Sprintf("(`%s`) IN (%s)", strings.Join(analysis.Columns, "`, `"), strings.Join(tuples, ", "))
+	args = rawArgs(values[:len(tuples)*colQty])
+	return
+}
+
+// ChecksumBatch
+//  checksum the rows of a batch by their unique key, only available for QueryType 1
+func ChecksumBatch(ctx context.Context, q Queryer, table string, batch *RowBatch) (checksum Checksum, err error) {
+	return ChecksumRows(ctx, q, table, batch.Columns, batch.Key.Where, rawArgs(batch.Key.Values))
+}
@@ -2,34 +2,38 @@ package data
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/dbadylan/go-mysql-archiver/internal/config"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 )
 
-func NewDB(m config.MySQL) (db *sql.DB, err error) {
+func NewDB(ctx context.Context, m config.MySQL) (db *sql.DB, err error) {
 	if db, err = sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=%s&interpolateParams=true", m.Username, m.Password, m.Address, m.Database, m.Charset)); err != nil {
 		return
 	}
 	db.SetMaxIdleConns(2)
 	db.SetMaxOpenConns(2)
-	err = db.Ping()
+	err = db.PingContext(ctx)
 	return
 }
 
-func explain(db *sql.DB, table string, where string) (keyName string, rowsEstimate int64, err error) {
+func explain(ctx context.Context, db *sql.DB, table string, where string, args ...interface{}) (keyName string, rowsEstimate int64, err error) {
 	query := fmt.Sprintf("EXPLAIN /* go-mysql-archiver */ SELECT 1 FROM `%s`", table)
 	if where != "" {
 		query += " WHERE " + where
 	}
 	var rows *sql.Rows
-	if rows, err = db.Query(query); err != nil {
+	if rows, err = db.QueryContext(ctx, query, args...); err != nil {
 		return
 	}
 	defer func() { _ = rows.Close() }()
@@ -78,8 +82,8 @@ func explain(db *sql.DB, table string, where string) (keyName string, rowsEstima
 
 // getOneUniqueKey
 //  try to get a non-nullable unique key, include primary key
-func getOneUniqueKey(db *sql.DB, database string, table string) (exist bool, columns []string, positions []int, err error) {
-	query := `SELECT /* go-mysql-archiver */ CONVERT(CONCAT('[', GROUP_CONCAT(CONCAT('"', c.COLUMN_NAME, '"') ORDER BY s.SEQ_IN_INDEX), ']'), JSON) columns, CONVERT(CONCAT('[', GROUP_CONCAT(c.ORDINAL_POSITION-1 ORDER BY s.SEQ_IN_INDEX), ']'), JSON) positions, MAX(NON_UNIQUE) non_unique, MAX(NULLABLE) nullable, MAX(CARDINALITY) cardinality
+func getOneUniqueKey(ctx context.Context, db *sql.DB, database string, table string) (exist bool, columns []string, positions []int, types []string, err error) {
+	query := `SELECT /* go-mysql-archiver */ CONVERT(CONCAT('[', GROUP_CONCAT(CONCAT('"', c.COLUMN_NAME, '"') ORDER BY s.SEQ_IN_INDEX), ']'), JSON) columns, CONVERT(CONCAT('[', GROUP_CONCAT(c.ORDINAL_POSITION-1 ORDER BY s.SEQ_IN_INDEX), ']'), JSON) positions, CONVERT(CONCAT('[', GROUP_CONCAT(CONCAT('"', IF(c.COLUMN_TYPE LIKE '%unsigned%', CONCAT(c.DATA_TYPE, ' unsigned'), c.DATA_TYPE), '"') ORDER BY s.SEQ_IN_INDEX), ']'), JSON) types, MAX(NON_UNIQUE) non_unique, MAX(NULLABLE) nullable, MAX(CARDINALITY) cardinality
 FROM information_schema.STATISTICS s
 JOIN information_schema.COLUMNS c
 ON s.TABLE_SCHEMA = c.TABLE_SCHEMA AND s.TABLE_NAME = c.TABLE_NAME AND s.COLUMN_NAME = c.COLUMN_NAME
@@ -90,11 +94,12 @@ LIMIT 1`
 	var (
 		columnsByte   []byte
 		positionsByte []byte
+		typesByte     []byte
 		_nonUnique    interface{}
 		_nullable     interface{}
 		_cardinality  interface{}
 	)
-	if err = db.QueryRow(query, database, table).Scan(&columnsByte, &positionsByte, &_nonUnique, &_nullable, &_cardinality); err != nil && errors.Is(err, sql.ErrNoRows) {
+	if err = db.QueryRowContext(ctx, query, database, table).Scan(&columnsByte, &positionsByte, &typesByte, &_nonUnique, &_nullable, &_cardinality); err != nil && errors.Is(err, sql.ErrNoRows) {
 		err = nil
 		return
 	}
@@ -104,12 +109,15 @@ LIMIT 1`
 	if err = json.Unmarshal(positionsByte, &positions); err != nil {
 		return
 	}
+	if err = json.Unmarshal(typesByte, &types); err != nil {
+		return
+	}
 	exist = true
 	return
 }
 
-func getOneKey(db *sql.DB, database string, table string) (exist bool, columns []string, positions []int, err error) {
-	query := `SELECT /* go-mysql-archiver */ CONVERT(CONCAT('[', GROUP_CONCAT(CONCAT('"', c.COLUMN_NAME, '"') ORDER BY SEQ_IN_INDEX), ']'), JSON) columns, CONVERT(CONCAT('[', GROUP_CONCAT(c.ORDINAL_POSITION-1 ORDER BY s.SEQ_IN_INDEX), ']'), JSON) positions, MAX(CARDINALITY) cardinality
+func getOneKey(ctx context.Context, db *sql.DB, database string, table string) (exist bool, columns []string, positions []int, types []string, err error) {
+	query := `SELECT /* go-mysql-archiver */ CONVERT(CONCAT('[', GROUP_CONCAT(CONCAT('"', c.COLUMN_NAME, '"') ORDER BY SEQ_IN_INDEX), ']'), JSON) columns, CONVERT(CONCAT('[', GROUP_CONCAT(c.ORDINAL_POSITION-1 ORDER BY s.SEQ_IN_INDEX), ']'), JSON) positions, CONVERT(CONCAT('[', GROUP_CONCAT(CONCAT('"', IF(c.COLUMN_TYPE LIKE '%unsigned%', CONCAT(c.DATA_TYPE, ' unsigned'), c.DATA_TYPE), '"') ORDER BY s.SEQ_IN_INDEX), ']'), JSON) types, MAX(CARDINALITY) cardinality
 FROM information_schema.STATISTICS s
 JOIN information_schema.COLUMNS c
 ON s.TABLE_SCHEMA = c.TABLE_SCHEMA AND s.TABLE_NAME = c.TABLE_NAME AND s.COLUMN_NAME = c.COLUMN_NAME
@@ -120,9 +128,10 @@ LIMIT 1`
 	var (
 		columnsByte   []byte
 		positionsByte []byte
+		typesByte     []byte
 		_cardinality  interface{}
 	)
-	if err = db.QueryRow(query, database, table).Scan(&columnsByte, &positionsByte, &_cardinality); err != nil && errors.Is(err, sql.ErrNoRows) {
+	if err = db.QueryRowContext(ctx, query, database, table).Scan(&columnsByte, &positionsByte, &typesByte, &_cardinality); err != nil && errors.Is(err, sql.ErrNoRows) {
 		err = nil
 		return
 	}
@@ -132,12 +141,15 @@ LIMIT 1`
 	if err = json.Unmarshal(positionsByte, &positions); err != nil {
 		return
 	}
+	if err = json.Unmarshal(typesByte, &types); err != nil {
+		return
+	}
 	exist = true
 	return
 }
 
-func getKeyByName(db *sql.DB, database string, table string, key string) (exist bool, columns []string, positions []int, err error) {
-	query := `SELECT /* go-mysql-archiver */ CONVERT(CONCAT('[', GROUP_CONCAT(CONCAT('"', c.COLUMN_NAME, '"') ORDER BY SEQ_IN_INDEX), ']'), JSON) columns, CONVERT(CONCAT('[', GROUP_CONCAT(c.ORDINAL_POSITION-1 ORDER BY s.SEQ_IN_INDEX), ']'), JSON) positions
+func getKeyByName(ctx context.Context, db *sql.DB, database string, table string, key string) (exist bool, columns []string, positions []int, types []string, err error) {
+	query := `SELECT /* go-mysql-archiver */ CONVERT(CONCAT('[', GROUP_CONCAT(CONCAT('"', c.COLUMN_NAME, '"') ORDER BY SEQ_IN_INDEX), ']'), JSON) columns, CONVERT(CONCAT('[', GROUP_CONCAT(c.ORDINAL_POSITION-1 ORDER BY s.SEQ_IN_INDEX), ']'), JSON) positions, CONVERT(CONCAT('[', GROUP_CONCAT(CONCAT('"', IF(c.COLUMN_TYPE LIKE '%unsigned%', CONCAT(c.DATA_TYPE, ' unsigned'), c.DATA_TYPE), '"') ORDER BY s.SEQ_IN_INDEX), ']'), JSON) types
 FROM information_schema.STATISTICS s
 JOIN information_schema.COLUMNS c
 ON s.TABLE_SCHEMA = c.TABLE_SCHEMA AND s.TABLE_NAME = c.TABLE_NAME AND s.COLUMN_NAME = c.COLUMN_NAME
@@ -146,8 +158,9 @@ GROUP BY s.INDEX_NAME`
 	var (
 		columnsByte   []byte
 		positionsByte []byte
+		typesByte     []byte
 	)
-	if err = db.QueryRow(query, database, table, key).Scan(&columnsByte, &positionsByte); err != nil && errors.Is(err, sql.ErrNoRows) {
+	if err = db.QueryRowContext(ctx, query, database, table, key).Scan(&columnsByte, &positionsByte, &typesByte); err != nil && errors.Is(err, sql.ErrNoRows) {
 		err = nil
 		return
 	}
@@ -157,6 +170,9 @@ GROUP BY s.INDEX_NAME`
 	if err = json.Unmarshal(positionsByte, &positions); err != nil {
 		return
 	}
+	if err = json.Unmarshal(typesByte, &types); err != nil {
+		return
+	}
 	exist = true
 	return
 }
@@ -166,17 +182,18 @@ type Analysis struct {
 	QueryType     int
 	Columns       []string
 	Positions     []int
+	Types         []string
 }
 
-func AnalyzeQuery(db *sql.DB, database string, table string, where string) (analysis Analysis, err error) {
+func AnalyzeQuery(ctx context.Context, db *sql.DB, database string, table string, where string) (analysis Analysis, err error) {
 	var (
 		keyName string
 		exist   bool
 	)
-	if keyName, analysis.RowsEstimated, err = explain(db, table, where); err != nil {
+	if keyName, analysis.RowsEstimated, err = explain(ctx, db, table, where); err != nil {
 		return
 	}
-	if exist, analysis.Columns, analysis.Positions, err = getOneUniqueKey(db, database, table); err != nil {
+	if exist, analysis.Columns, analysis.Positions, analysis.Types, err = getOneUniqueKey(ctx, db, database, table); err != nil {
 		return
 	}
 	if exist {
@@ -186,7 +203,7 @@ func AnalyzeQuery(db *sql.DB, database string, table string, where string) (anal
 	if keyName == "" {
 		goto F
 	}
-	if exist, analysis.Columns, analysis.Positions, err = getKeyByName(db, database, table, keyName); err != nil {
+	if exist, analysis.Columns, analysis.Positions, analysis.Types, err = getKeyByName(ctx, db, database, table, keyName); err != nil {
 		return
 	}
 	if exist {
@@ -194,7 +211,7 @@ func AnalyzeQuery(db *sql.DB, database string, table string, where string) (anal
 		return
 	}
 F:
-	if exist, analysis.Columns, analysis.Positions, err = getOneKey(db, database, table); err != nil {
+	if exist, analysis.Columns, analysis.Positions, analysis.Types, err = getOneKey(ctx, db, database, table); err != nil {
 		return
 	}
 	if exist {
@@ -205,58 +222,168 @@ F:
 	return
 }
 
-type SelectParam struct {
-	DB       *sql.DB
-	Table    string
-	Where    string
-	Limit    int64
-	Analysis Analysis
+// ListTables
+//  the base tables of a database, in the order of their names
+func ListTables(ctx context.Context, db *sql.DB, database string) (tables []string, err error) {
+	query := "SELECT /* go-mysql-archiver */ TABLE_NAME FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_TYPE = 'BASE TABLE' ORDER BY TABLE_NAME"
+	var rows *sql.Rows
+	if rows, err = db.QueryContext(ctx, query, database); err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var table string
+		if err = rows.Scan(&table); err != nil {
+			return
+		}
+		tables = append(tables, table)
+	}
+	err = rows.Err()
+	return
+}
+
+// keyArg
+//  convert a raw key value into an argument that compares in the order of the index
+func keyArg(columnType string, value []byte) interface{} {
+	if value == nil {
+		return nil
+	}
+	fields := strings.Fields(columnType)
+	if len(fields) == 0 {
+		return string(value)
+	}
+	switch fields[0] {
+	case "tinyint", "smallint", "mediumint", "int", "bigint":
+		if len(fields) > 1 && fields[1] == "unsigned" {
+			if v, err := strconv.ParseUint(string(value), 10, 64); err == nil {
+				return v
+			}
+		} else if v, err := strconv.ParseInt(string(value), 10, 64); err == nil {
+			return v
+		}
+		return string(value)
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob", "bit":
+		return value
+	default:
+		return string(value)
+	}
+}
+
+func keyArgs(types []string, values [][]byte) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		var columnType string
+		if i < len(types) {
+			columnType = types[i]
+		}
+		args[i] = keyArg(columnType, value)
+	}
+	return args
 }
 
-type Insert struct {
-	Columns   string
-	Values    *string
-	ValueList *[]interface{}
+// seekClause
+//  build the keyset bound that skips the rows before the cursor
+func seekClause(analysis Analysis, cursor [][]byte) (clause string, args []interface{}) {
+	if len(cursor) == 0 || len(cursor) != len(analysis.Columns) {
+		return
+	}
+	for _, value := range cursor {
+		// NULL never compares, fall back to scanning from the start
+		if value == nil {
+			return
+		}
+	}
+	if clause = seekCondition(analysis); clause != "" {
+		args = keyArgs(analysis.Types, cursor)
+	}
+	return
 }
 
-type Delete struct {
-	Where     *string
-	ValueList *[]interface{}
+func seekCondition(analysis Analysis) string {
+	var operator string
+	switch analysis.QueryType {
+	case 1:
+		operator = ">"
+	case 2:
+		// rows sharing the cursor key may not have been fetched yet, they are still there since the
+		// fetched ones have been deleted
+		operator = ">="
+	default:
+		return ""
+	}
+	placeholders := make([]string, len(analysis.Columns))
+	for i := range placeholders {
+		placeholders[i] = "?"
+	}
+	return "(`" + strings.Join(analysis.Columns, "`, `") + "`) " + operator + " (" + strings.Join(placeholders, ", ") + ")"
 }
 
-type SelectResp struct {
-	Insert Insert
-	Delete Delete
+func joinConditions(conditions ...string) string {
+	var parts []string
+	for _, condition := range conditions {
+		if condition != "" {
+			parts = append(parts, condition)
+		}
+	}
+	if len(parts) < 2 {
+		return strings.Join(parts, "")
+	}
+	for i := range parts {
+		parts[i] = "(" + parts[i] + ")"
+	}
+	return strings.Join(parts, " AND ")
+}
 
-	Rows int64
+type SelectParam struct {
+	DB       *sql.DB
+	Table    string
+	Where    string
+	Limit    int64
+	Analysis Analysis
+	// Cursor is the key of the last row fetched by the previous round
+	Cursor [][]byte
 }
 
-func SelectRows(param *SelectParam) (resp *SelectResp, err error) {
-	query := fmt.Sprintf("SELECT /* go-mysql-archiver */ * FROM `%s`", param.Table)
-	if param.Where != "" {
-		query += " WHERE " + param.Where
+func selectQuery(table string, where string, limit int64, analysis Analysis) string {
+	query := fmt.Sprintf("SELECT /* go-mysql-archiver */ * FROM `%s`", table)
+	if where != "" {
+		query += " WHERE " + where
 	}
-	if param.Analysis.QueryType == 2 {
-		query += " ORDER BY " + "`" + strings.Join(param.Analysis.Columns, "`, `") + "`"
+	if analysis.QueryType == 1 || analysis.QueryType == 2 {
+		query += " ORDER BY " + "`" + strings.Join(analysis.Columns, "`, `") + "`"
 	}
-	query += fmt.Sprintf(" LIMIT %d", param.Limit)
+	query += fmt.Sprintf(" LIMIT %d", limit)
+	return query
+}
+
+func SelectRows(ctx context.Context, param *SelectParam) (batch *RowBatch, err error) {
+	seek, seekArgs := seekClause(param.Analysis, param.Cursor)
+	query := selectQuery(param.Table, joinConditions(param.Where, seek), param.Limit, param.Analysis)
 
 	var rows *sql.Rows
-	if rows, err = param.DB.Query(query); err != nil {
+	if rows, err = param.DB.QueryContext(ctx, query, seekArgs...); err != nil {
 		return
 	}
 	defer func() { _ = rows.Close() }()
 
-	resp = new(SelectResp)
+	batch = &RowBatch{Table: param.Table}
 
 	var columns []string
 	if columns, err = rows.Columns(); err != nil {
 		return
 	}
-	resp.Insert.Columns = "`" + strings.Join(columns, "`, `") + "`"
+	batch.Columns = columns
+
+	var columnTypes []*sql.ColumnType
+	if columnTypes, err = rows.ColumnTypes(); err != nil {
+		return
+	}
+	batch.Types = make([]string, len(columnTypes))
+	for i, columnType := range columnTypes {
+		batch.Types[i] = columnType.DatabaseTypeName()
+	}
 
 	allColQty := len(columns)
-	allColMaxIdx := allColQty - 1
 	dest := make([]interface{}, allColQty)
 	for i := 0; i < allColQty; i++ {
 		dest[i] = new([]byte)
@@ -271,30 +398,20 @@ func SelectRows(param *SelectParam) (resp *SelectResp, err error) {
 	}
 
 	var (
-		valuesSubClauses = make([]string, 0, param.Limit)
-		whereSubClauses  = make([]string, 0, param.Limit)
-		allValueList     = make([]interface{}, 0, param.Limit*int64(allColQty))
-		keyValueList     = make([]interface{}, 0, keyValueMaxLen)
+		whereSubClauses = make([]string, 0, param.Limit)
+		allValueList    = make([][]byte, 0, param.Limit*int64(allColQty))
+		keyValueList    = make([][]byte, 0, keyValueMaxLen)
 	)
 	for rows.Next() {
 		if err = rows.Scan(dest...); err != nil {
 			return
 		}
 
-		var (
-			valuesSubClauseBuf bytes.Buffer
-			columnExpressions  = make([]string, allColQty)
-		)
-		valuesSubClauseBuf.WriteString("(")
+		columnExpressions := make([]string, allColQty)
 		for i := 0; i < allColQty; i++ {
 			value := *(dest[i].(*[]byte))
 			allValueList = append(allValueList, value)
 
-			valuesSubClauseBuf.WriteString("?")
-			if i != allColMaxIdx {
-				valuesSubClauseBuf.WriteString(", ")
-			}
-
 			if param.Analysis.QueryType == 3 {
 				var operator string
 				if value == nil {
@@ -316,7 +433,7 @@ func SelectRows(param *SelectParam) (resp *SelectResp, err error) {
 		case 1:
 			placeholders := make([]string, len(param.Analysis.Positions))
 			for index, position := range param.Analysis.Positions {
-				keyValueList = append(keyValueList, allValueList[int64(allColQty)*resp.Rows+int64(position)])
+				keyValueList = append(keyValueList, allValueList[int64(allColQty)*batch.Rows+int64(position)])
 				placeholders[index] = "?"
 			}
 			whereSubClauses = append(whereSubClauses, "("+strings.Join(placeholders, ", ")+")")
@@ -324,44 +441,69 @@ func SelectRows(param *SelectParam) (resp *SelectResp, err error) {
 			whereSubClauses = append(whereSubClauses, "("+strings.Join(columnExpressions, " AND ")+")")
 		}
 
-		valuesSubClauseBuf.WriteString(")")
-		valuesSubClauses = append(valuesSubClauses, valuesSubClauseBuf.String())
-
-		resp.Rows++
+		batch.Rows++
+	}
+	if err = rows.Err(); err != nil {
+		return
 	}
 
-	valuesClause := strings.Join(valuesSubClauses, ", ")
+	if batch.Rows > 0 && (param.Analysis.QueryType == 1 || param.Analysis.QueryType == 2) {
+		offset := int64(allColQty) * (batch.Rows - 1)
+		batch.Cursor = make([][]byte, len(param.Analysis.Positions))
+		for index, position := range param.Analysis.Positions {
+			batch.Cursor[index] = allValueList[offset+int64(position)]
+		}
+	}
 
 	var whereClause string
 	switch param.Analysis.QueryType {
 	case 1:
 		whereClause = "(`" + strings.Join(param.Analysis.Columns, "`, `") + "`) IN (" + strings.Join(whereSubClauses, ", ") + ")"
 	case 2:
-		whereClause = param.Where
+		whereClause = joinConditions(param.Where, seek)
+		if seek != "" {
+			keyValueList = param.Cursor
+		}
 	case 3:
 		whereClause = strings.Join(whereSubClauses, " OR ")
 	}
 
-	resp.Insert.Values = &valuesClause
-	resp.Insert.ValueList = &allValueList
-	resp.Delete.Where = &whereClause
-	resp.Delete.ValueList = &keyValueList
+	batch.Values = allValueList
+	batch.Key = Key{Where: whereClause, Values: keyValueList, Limit: param.Limit}
 
 	return
 }
 
 type InsertParam struct {
-	Tx        *sql.Tx
-	Table     string
-	Columns   string
-	Values    *string
-	ValueList *[]interface{}
+	Tx    Tx
+	Table string
+	Batch *RowBatch
 }
 
-func InsertRows(param *InsertParam) (rowsAffected int64, err error) {
-	query := fmt.Sprintf("INSERT /* go-mysql-archiver */ INTO `%s` (%s) VALUES %s", param.Table, param.Columns, *param.Values)
+func insertQuery(table string, columns string, values string) string {
+	return fmt.Sprintf("INSERT /* go-mysql-archiver */ INTO `%s` (%s) VALUES %s", table, columns, values)
+}
+
+// columnList
+//  the quoted column names separated by commas
+func columnList(columns []string) string {
+	return "`" + strings.Join(columns, "`, `") + "`"
+}
+
+func InsertRows(ctx context.Context, param *InsertParam) (rowsAffected int64, err error) {
+	batch := param.Batch
+	placeholders := make([]string, len(batch.Columns))
+	for i := range placeholders {
+		placeholders[i] = "?"
+	}
+	tuple := "(" + strings.Join(placeholders, ", ") + ")"
+	tuples := make([]string, batch.Rows)
+	for i := range tuples {
+		tuples[i] = tuple
+	}
+	query := insertQuery(param.Table, columnList(batch.Columns), strings.Join(tuples, ", "))
 	var result sql.Result
-	if result, err = param.Tx.Exec(query, *param.ValueList...); err != nil {
+	if result, err = param.Tx.ExecContext(ctx, query, rawArgs(batch.Values)...); err != nil {
 		return
 	}
 	rowsAffected, err = result.RowsAffected()
@@ -369,29 +511,44 @@ func InsertRows(param *InsertParam) (rowsAffected int64, err error) {
 }
 
 type DeleteParam struct {
-	Tx        *sql.Tx
-	Table     string
-	Where     *string
-	Limit     int64
-	ValueList *[]interface{}
-	Analysis  Analysis
+	Tx       Tx
+	Table    string
+	Where    *string
+	Limit    int64
+	Values   [][]byte
+	Analysis Analysis
+}
+
+func rawArgs(values [][]byte) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+	return args
 }
 
-func DeleteRows(param *DeleteParam) (rowsAffected int64, err error) {
-	query := fmt.Sprintf("DELETE /* go-mysql-archiver */ FROM `%s`", param.Table)
-	if *param.Where != "" {
-		query += fmt.Sprintf(" WHERE %s", *param.Where)
+func deleteQuery(table string, where string, limit int64, analysis Analysis) string {
+	query := fmt.Sprintf("DELETE /* go-mysql-archiver */ FROM `%s`", table)
+	if where != "" {
+		query += fmt.Sprintf(" WHERE %s", where)
+	}
+	switch analysis.QueryType {
+	case 2:
+		query += fmt.Sprintf(" ORDER BY %s LIMIT %d", "`"+strings.Join(analysis.Columns, "`, `")+"`", limit)
+	case 3:
+		query += fmt.Sprintf(" LIMIT %d", limit)
 	}
+	return query
+}
+
+func DeleteRows(ctx context.Context, param *DeleteParam) (rowsAffected int64, err error) {
+	query := deleteQuery(param.Table, *param.Where, param.Limit, param.Analysis)
 	var result sql.Result
 	switch param.Analysis.QueryType {
-	case 1:
-		result, err = param.Tx.Exec(query, *param.ValueList...)
+	case 1, 3:
+		result, err = param.Tx.ExecContext(ctx, query, rawArgs(param.Values)...)
 	case 2:
-		query += fmt.Sprintf(" ORDER BY %s LIMIT %d", "`"+strings.Join(param.Analysis.Columns, "`, `")+"`", param.Limit)
-		result, err = param.Tx.Exec(query)
-	case 3:
-		query += fmt.Sprintf(" LIMIT %d", param.Limit)
-		result, err = param.Tx.Exec(query, *param.ValueList...)
+		result, err = param.Tx.ExecContext(ctx, query, keyArgs(param.Analysis.Types, param.Values)...)
 	default:
 		return
 	}
@@ -401,3 +558,172 @@ func DeleteRows(param *DeleteParam) (rowsAffected int64, err error) {
 	rowsAffected, err = result.RowsAffected()
 	return
 }
+
+// EstimateRows
+//  estimate the rows left after the cursor, by EXPLAIN, or by COUNT(*) which is exact but scans the rows
+func EstimateRows(ctx context.Context, db *sql.DB, table string, where string, analysis Analysis, cursor [][]byte, count bool) (rows int64, err error) {
+	seek, args := seekClause(analysis, cursor)
+	where = joinConditions(where, seek)
+	if !count {
+		_, rows, err = explain(ctx, db, table, where, args...)
+		return
+	}
+	query := fmt.Sprintf("SELECT /* go-mysql-archiver */ COUNT(*) FROM `%s`", table)
+	if where != "" {
+		query += " WHERE " + where
+	}
+	err = db.QueryRowContext(ctx, query, args...).Scan(&rows)
+	return
+}
+
+// CountKeys
+//  count the rows whose unique key is one of the given key values, only available for QueryType 1
+func CountKeys(ctx context.Context, db *sql.DB, table string, analysis Analysis, values [][]byte) (count int64, err error) {
+	colQty := len(analysis.Columns)
+	if analysis.QueryType != 1 || colQty == 0 || len(values) == 0 {
+		return
+	}
+	clause, args := KeysClause(analysis, values)
+	query := fmt.Sprintf("SELECT /* go-mysql-archiver */ COUNT(*) FROM `%s` WHERE %s", table, clause)
+	err = db.QueryRowContext(ctx, query, args...).Scan(&count)
+	return
+}
+
+// ReplicaLag
+//  get the replication lag of a replica from the pt-heartbeat table if given, otherwise from the replica status,
+//  running is false when the replication threads are not running
+func ReplicaLag(ctx context.Context, db *sql.DB, heartbeatTable string) (lag time.Duration, running bool, err error) {
+	if heartbeatTable != "" {
+		var micro sql.NullInt64
+		if err = db.QueryRowContext(ctx, fmt.Sprintf("SELECT /* go-mysql-archiver */ TIMESTAMPDIFF(MICROSECOND, MAX(`ts`), NOW(6)) FROM %s", heartbeatTable)).Scan(&micro); err != nil {
+			return
+		}
+		lag, running = time.Duration(micro.Int64)*time.Microsecond, micro.Valid
+		return
+	}
+
+	var rows *sql.Rows
+	if rows, err = db.QueryContext(ctx, "SHOW REPLICA STATUS"); err != nil {
+		// before 8.0.22
+		if rows, err = db.QueryContext(ctx, "SHOW SLAVE STATUS"); err != nil {
+			return
+		}
+	}
+	defer func() { _ = rows.Close() }()
+
+	var columns []string
+	if columns, err = rows.Columns(); err != nil {
+		return
+	}
+	index := -1
+	for i, column := range columns {
+		if column == "Seconds_Behind_Source" || column == "Seconds_Behind_Master" {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		err = errors.New("no Seconds_Behind_Master in the replica status")
+		return
+	}
+	dest := make([]interface{}, len(columns))
+	for i := range dest {
+		dest[i] = new(sql.RawBytes)
+	}
+	var channels int
+	running = true
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return
+		}
+		channels++
+		value := *(dest[index].(*sql.RawBytes))
+		if value == nil {
+			running = false
+			continue
+		}
+		var seconds int64
+		if seconds, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+			return
+		}
+		if d := time.Duration(seconds) * time.Second; d > lag {
+			lag = d
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	if channels == 0 {
+		err = errors.New("the instance is not a replica")
+	}
+	return
+}
+
+// GlobalStatus
+//  get the values of the given global status variables, the names in the result are lowercase
+func GlobalStatus(ctx context.Context, db *sql.DB, names []string) (values map[string]int64, err error) {
+	if len(names) == 0 {
+		return
+	}
+	placeholders := make([]string, len(names))
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		placeholders[i] = "?"
+		args[i] = name
+	}
+	var rows *sql.Rows
+	if rows, err = db.QueryContext(ctx, "SHOW GLOBAL STATUS WHERE Variable_name IN ("+strings.Join(placeholders, ", ")+")", args...); err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+
+	values = make(map[string]int64, len(names))
+	var (
+		name  string
+		value string
+	)
+	for rows.Next() {
+		if err = rows.Scan(&name, &value); err != nil {
+			return
+		}
+		var v int64
+		if v, err = strconv.ParseInt(value, 10, 64); err != nil {
+			err = fmt.Errorf("status variable %s is not an integer: %w", name, err)
+			return
+		}
+		values[strings.ToLower(name)] = v
+	}
+	err = rows.Err()
+	return
+}
+
+// IsTransient
+//  whether the error is likely to go away by rolling back and trying again, such as deadlocks, lock wait
+//  timeouts, lost connections and writes rejected by a server that has just become read-only after a failover,
+//  a statement that has run into its timeout counts as well, while a cancelled one does not
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	// the driver reports a lost connection as one of these errors, never as a MySQLError with a client error number
+	// such as 2006 or 2013
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return false
+	}
+	switch mysqlErr.Number {
+	case 1205, // ER_LOCK_WAIT_TIMEOUT
+		1213, // ER_LOCK_DEADLOCK
+		1290, // ER_OPTION_PREVENTS_STATEMENT, such as --read-only
+		1792, // ER_CANT_EXECUTE_IN_READ_ONLY_TRANSACTION
+		1836: // ER_READ_ONLY_MODE
+		return true
+	}
+	return false
+}
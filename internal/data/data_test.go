@@ -0,0 +1,111 @@
+package data
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestSeekClause(t *testing.T) {
+	single := Analysis{QueryType: 1, Columns: []string{"id"}, Types: []string{"bigint unsigned"}}
+	composite := Analysis{QueryType: 1, Columns: []string{"a", "b"}, Types: []string{"int", "varchar"}}
+	nonUnique := Analysis{QueryType: 2, Columns: []string{"a", "b"}, Types: []string{"int", "varchar"}}
+	for _, c := range []struct {
+		name       string
+		analysis   Analysis
+		cursor     [][]byte
+		wantClause string
+		wantArgs   []interface{}
+	}{
+		{name: "first batch", analysis: single},
+		{
+			name:       "single unique key",
+			analysis:   single,
+			cursor:     [][]byte{[]byte("42")},
+			wantClause: "(`id`) > (?)",
+			wantArgs:   []interface{}{uint64(42)},
+		},
+		{
+			name:       "composite unique key",
+			analysis:   composite,
+			cursor:     [][]byte{[]byte("-1"), []byte("x")},
+			wantClause: "(`a`, `b`) > (?, ?)",
+			wantArgs:   []interface{}{int64(-1), "x"},
+		},
+		{
+			// the rows sharing the cursor key may not have been fetched yet
+			name:       "non-unique key",
+			analysis:   nonUnique,
+			cursor:     [][]byte{[]byte("1"), []byte("x")},
+			wantClause: "(`a`, `b`) >= (?, ?)",
+			wantArgs:   []interface{}{int64(1), "x"},
+		},
+		{name: "no key", analysis: Analysis{QueryType: 3}, cursor: [][]byte{[]byte("1")}},
+		{name: "null in the cursor", analysis: composite, cursor: [][]byte{[]byte("1"), nil}},
+		{name: "cursor of another key", analysis: composite, cursor: [][]byte{[]byte("1")}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			clause, args := seekClause(c.analysis, c.cursor)
+			if clause != c.wantClause {
+				t.Errorf("got the clause %q, want %q", clause, c.wantClause)
+			}
+			if !reflect.DeepEqual(args, c.wantArgs) {
+				t.Errorf("got the args %#v, want %#v", args, c.wantArgs)
+			}
+		})
+	}
+}
+
+func TestKeyArg(t *testing.T) {
+	for _, c := range []struct {
+		columnType string
+		value      []byte
+		want       interface{}
+	}{
+		{columnType: "int", value: []byte("-7"), want: int64(-7)},
+		{columnType: "bigint unsigned", value: []byte("18446744073709551615"), want: uint64(18446744073709551615)},
+		{columnType: "bigint", value: []byte("18446744073709551615"), want: "18446744073709551615"},
+		{columnType: "varchar", value: []byte("007"), want: "007"},
+		{columnType: "varbinary", value: []byte{0xff, 0x00}, want: []byte{0xff, 0x00}},
+		{columnType: "decimal", value: []byte("1.50"), want: "1.50"},
+		{columnType: "", value: []byte("1"), want: "1"},
+		{columnType: "int", value: nil, want: nil},
+	} {
+		if got := keyArg(c.columnType, c.value); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("keyArg(%q, %q) = %#v, want %#v", c.columnType, c.value, got, c.want)
+		}
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "lock wait timeout", err: &mysql.MySQLError{Number: 1205}, want: true},
+		{name: "deadlock", err: &mysql.MySQLError{Number: 1213}, want: true},
+		{name: "read-only option", err: &mysql.MySQLError{Number: 1290}, want: true},
+		{name: "read-only transaction", err: &mysql.MySQLError{Number: 1792}, want: true},
+		{name: "read-only mode", err: &mysql.MySQLError{Number: 1836}, want: true},
+		{name: "wrapped deadlock", err: fmt.Errorf("delete: %w", &mysql.MySQLError{Number: 1213}), want: true},
+		{name: "bad connection", err: driver.ErrBadConn, want: true},
+		{name: "invalid connection", err: mysql.ErrInvalidConn, want: true},
+		{name: "statement timeout", err: context.DeadlineExceeded, want: true},
+		{name: "cancelled", err: context.Canceled, want: false},
+		{name: "duplicate key", err: &mysql.MySQLError{Number: 1062}, want: false},
+		{name: "other error", err: errors.New("failure"), want: false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if got := IsTransient(c.err); got != c.want {
+				t.Errorf("IsTransient(%v) = %v, want %v", c.err, got, c.want)
+			}
+		})
+	}
+}
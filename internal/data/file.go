@@ -0,0 +1,461 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+	FormatSQL   = "sql"
+
+	CompressNone = "none"
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+)
+
+type valueKind int
+
+const (
+	kindString valueKind = iota
+	kindNumber
+	kindBinary
+)
+
+func kindOf(databaseTypeName string) valueKind {
+	switch strings.TrimPrefix(databaseTypeName, "UNSIGNED ") {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "DECIMAL", "FLOAT", "DOUBLE", "YEAR":
+		return kindNumber
+	case "BINARY", "VARBINARY", "TINYBLOB", "BLOB", "MEDIUMBLOB", "LONGBLOB", "BIT", "GEOMETRY":
+		return kindBinary
+	default:
+		return kindString
+	}
+}
+
+type flushWriter interface {
+	Write(p []byte) (int, error)
+	Flush() error
+	Close() error
+}
+
+// nopCloser
+//  the uncompressed writer, closing it only flushes the buffer
+type nopCloser struct {
+	*bufio.Writer
+}
+
+func (n nopCloser) Close() error {
+	return n.Flush()
+}
+
+type FileSinkParam struct {
+	Dir      string
+	Format   string
+	Compress string
+	// MaxSize is the uncompressed size in bytes after which the file is rotated, 0 means never
+	MaxSize int64
+	// Name is the common part of the file names, such as database.table
+	Name string
+	// Table is the table name used by the INSERT statements of the sql format
+	Table string
+}
+
+// FileSink
+//  write rows to local files, a file is only rotated between batches
+type FileSink struct {
+	param  *FileSinkParam
+	start  string
+	seq    int
+	file   *os.File
+	buf    *bufio.Writer
+	writer flushWriter
+	// streaming is true once a commit has begun its gzip member or zstd frame
+	streaming bool
+	written   int64
+	// synced is the size of the file at the end of the last commit
+	synced int64
+}
+
+func NewFileSink(param *FileSinkParam) (sink *FileSink, err error) {
+	if err = os.MkdirAll(param.Dir, 0755); err != nil {
+		return
+	}
+	sink = &FileSink{
+		param: param,
+		start: time.Now().Local().Format("20060102150405"),
+	}
+	return
+}
+
+func (s *FileSink) open() (err error) {
+	s.seq++
+	name := fmt.Sprintf("%s.%s.%06d.%s", s.param.Name, s.start, s.seq, s.param.Format)
+	switch s.param.Compress {
+	case CompressGzip:
+		name += ".gz"
+	case CompressZstd:
+		name += ".zst"
+	}
+	if s.file, err = os.OpenFile(filepath.Join(s.param.Dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644); err != nil {
+		return
+	}
+	s.buf = bufio.NewWriterSize(s.file, 1<<20)
+	switch s.param.Compress {
+	case CompressGzip:
+		s.writer = gzip.NewWriter(s.buf)
+	case CompressZstd:
+		if s.writer, err = zstd.NewWriter(s.buf); err != nil {
+			return
+		}
+	default:
+		s.writer = nopCloser{s.buf}
+	}
+	s.streaming = false
+	s.written = 0
+	s.synced = 0
+
+	var dir *os.File
+	if dir, err = os.Open(s.param.Dir); err != nil {
+		return
+	}
+	defer func() { _ = dir.Close() }()
+	err = dir.Sync()
+	return
+}
+
+// Begin
+//  the rows written are only appended to the file and synced once committed, since a file can not be rolled back
+func (s *FileSink) Begin(ctx context.Context) (SinkTx, error) {
+	return &bufferedTx{commit: s.commit}, nil
+}
+
+// commit
+//  the file is truncated back to the end of the last commit when a commit fails, so that none of its rows are left
+//  in the file to be archived once more by the next run
+func (s *FileSink) commit(batches []*RowBatch) (err error) {
+	defer func() {
+		if err != nil {
+			s.discard()
+		}
+	}()
+	for _, batch := range batches {
+		if err = s.write(batch); err != nil {
+			return
+		}
+	}
+	err = s.sync()
+	return
+}
+
+// write
+//  append the rows of a batch to the current file, they are not durable until synced
+func (s *FileSink) write(batch *RowBatch) (err error) {
+	if batch.Rows == 0 {
+		return
+	}
+	if s.file == nil {
+		if err = s.open(); err != nil {
+			return
+		}
+	}
+	if !s.streaming {
+		// every commit is a gzip member or a zstd frame of its own, so that the file is still valid once
+		// truncated back to the end of any of them
+		switch w := s.writer.(type) {
+		case *gzip.Writer:
+			w.Reset(s.buf)
+		case *zstd.Encoder:
+			w.Reset(s.buf)
+		}
+		s.streaming = true
+	}
+	var content []byte
+	if content, err = formatRows(s.param.Format, s.param.Table, s.written == 0, batch); err != nil {
+		return
+	}
+	if _, err = s.writer.Write(content); err != nil {
+		return
+	}
+	s.written += int64(len(content))
+	return
+}
+
+// sync
+//  end the gzip member or zstd frame of the commit and flush it to disk, the file is rotated once it has reached
+//  the max size
+func (s *FileSink) sync() (err error) {
+	if s.file == nil || !s.streaming {
+		return
+	}
+	if err = s.writer.Close(); err != nil {
+		return
+	}
+	s.streaming = false
+	if err = s.buf.Flush(); err != nil {
+		return
+	}
+	if err = s.file.Sync(); err != nil {
+		return
+	}
+	if s.synced, err = s.file.Seek(0, io.SeekCurrent); err != nil {
+		return
+	}
+	if s.param.MaxSize > 0 && s.written >= s.param.MaxSize {
+		err = s.Close()
+	}
+	return
+}
+
+// discard
+//  drop what a failed commit has left in the file, the rows after go to a new file
+func (s *FileSink) discard() {
+	if s.file == nil {
+		return
+	}
+	_ = s.file.Truncate(s.synced)
+	_ = s.file.Sync()
+	_ = s.file.Close()
+	s.file = nil
+}
+
+// Close
+//  every commit has been synced or discarded, so there is nothing left to flush
+func (s *FileSink) Close() (err error) {
+	if s.file == nil {
+		return
+	}
+	err = s.file.Close()
+	s.file = nil
+	return
+}
+
+// formatRows
+//  render the rows of a batch in one of the file formats, header tells whether the csv header comes first, table is
+//  the table of the INSERT statements of the sql format
+func formatRows(format string, table string, header bool, batch *RowBatch) (content []byte, err error) {
+	var (
+		buf    bytes.Buffer
+		colQty = len(batch.Columns)
+		kinds  = make([]valueKind, colQty)
+	)
+	for i, typeName := range batch.Types {
+		kinds[i] = kindOf(typeName)
+	}
+
+	switch format {
+	case FormatCSV:
+		if header {
+			columns := make([][]byte, colQty)
+			for i, column := range batch.Columns {
+				columns[i] = []byte(column)
+			}
+			writeCSVRecord(&buf, columns)
+		}
+		for r := int64(0); r < batch.Rows; r++ {
+			writeCSVRecord(&buf, batch.Row(r))
+		}
+	case FormatJSONL:
+		keys := make([][]byte, colQty)
+		for i, column := range batch.Columns {
+			if keys[i], err = json.Marshal(column); err != nil {
+				return
+			}
+		}
+		for r := int64(0); r < batch.Rows; r++ {
+			buf.WriteString("{")
+			for i, value := range batch.Row(r) {
+				if i != 0 {
+					buf.WriteString(",")
+				}
+				buf.Write(keys[i])
+				buf.WriteString(":")
+				switch {
+				case value == nil:
+					buf.WriteString("null")
+				case kinds[i] == kindNumber:
+					buf.Write(value)
+				case kinds[i] == kindBinary:
+					buf.WriteString(`"` + base64.StdEncoding.EncodeToString(value) + `"`)
+				default:
+					var str []byte
+					if str, err = json.Marshal(string(value)); err != nil {
+						return
+					}
+					buf.Write(str)
+				}
+			}
+			buf.WriteString("}\n")
+		}
+	case FormatSQL:
+		fmt.Fprintf(&buf, "INSERT INTO `%s` (%s) VALUES ", table, columnList(batch.Columns))
+		for r := int64(0); r < batch.Rows; r++ {
+			if r != 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString("(")
+			for i, value := range batch.Row(r) {
+				if i != 0 {
+					buf.WriteString(", ")
+				}
+				switch {
+				case value == nil:
+					buf.WriteString("NULL")
+				case kinds[i] == kindNumber:
+					buf.Write(value)
+				case kinds[i] == kindBinary:
+					if len(value) == 0 {
+						buf.WriteString("''")
+					} else {
+						buf.WriteString("0x" + hex.EncodeToString(value))
+					}
+				default:
+					buf.WriteString("'")
+					escapeString(&buf, value)
+					buf.WriteString("'")
+				}
+			}
+			buf.WriteString(")")
+		}
+		buf.WriteString(";\n")
+	default:
+		err = fmt.Errorf("unsupported file format %q", format)
+		return
+	}
+	content = buf.Bytes()
+	return
+}
+
+// WriterSink
+//  write rows to a writer, such as stdout, in one of the file formats, the INSERT statements of the sql format are
+//  into the table of the batch when table is empty
+type WriterSink struct {
+	w      io.Writer
+	format string
+	table  string
+	// mu lets the tables archived at the same time commit one after another
+	mu sync.Mutex
+	// last is the table of the last batch written, a csv header is written whenever the table changes
+	last string
+}
+
+func NewWriterSink(w io.Writer, format string, table string) (sink *WriterSink, err error) {
+	switch format {
+	case FormatCSV, FormatJSONL, FormatSQL:
+	default:
+		err = fmt.Errorf("unsupported file format %q", format)
+		return
+	}
+	sink = &WriterSink{w: w, format: format, table: table}
+	return
+}
+
+func (s *WriterSink) Begin(ctx context.Context) (SinkTx, error) {
+	return &bufferedTx{commit: s.commit}, nil
+}
+
+func (s *WriterSink) commit(batches []*RowBatch) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, batch := range batches {
+		if batch.Rows == 0 {
+			continue
+		}
+		var content []byte
+		table := s.table
+		if table == "" {
+			table = batch.Table
+		}
+		if content, err = formatRows(s.format, table, batch.Table != s.last, batch); err != nil {
+			return
+		}
+		if _, err = s.w.Write(content); err != nil {
+			return
+		}
+		s.last = batch.Table
+	}
+	return
+}
+
+func (s *WriterSink) Close() error {
+	return nil
+}
+
+// bufferedTx
+//  hold the rows written until committed, for the sinks that can not roll back
+type bufferedTx struct {
+	batches []*RowBatch
+	commit  func(batches []*RowBatch) error
+}
+
+func (t *bufferedTx) Write(ctx context.Context, batch *RowBatch) (rows int64, err error) {
+	t.batches = append(t.batches, batch)
+	rows = batch.Rows
+	return
+}
+
+func (t *bufferedTx) Commit() error {
+	return t.commit(t.batches)
+}
+
+func (t *bufferedTx) Rollback() error {
+	t.batches = nil
+	return nil
+}
+
+// writeCSVRecord
+//  every value is quoted, so that NULL, which is an empty field without quotes, can be told from an empty string
+func writeCSVRecord(buf *bytes.Buffer, record [][]byte) {
+	for i, value := range record {
+		if i != 0 {
+			buf.WriteString(",")
+		}
+		if value == nil {
+			continue
+		}
+		buf.WriteString(`"`)
+		buf.Write(bytes.ReplaceAll(value, []byte(`"`), []byte(`""`)))
+		buf.WriteString(`"`)
+	}
+	buf.WriteString("\n")
+}
+
+// escapeString
+//  escape a string literal the way mysqldump does
+func escapeString(buf *bytes.Buffer, value []byte) {
+	for _, c := range value {
+		switch c {
+		case 0:
+			buf.WriteString(`\0`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\'':
+			buf.WriteString(`\'`)
+		case '"':
+			buf.WriteString(`\"`)
+		case '\032':
+			buf.WriteString(`\Z`)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteCSVRecord(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		record [][]byte
+		want   string
+	}{
+		{name: "plain", record: [][]byte{[]byte("1"), []byte("a")}, want: `"1","a"` + "\n"},
+		{name: "null", record: [][]byte{[]byte("1"), nil}, want: `"1",` + "\n"},
+		{name: "empty", record: [][]byte{[]byte("1"), {}}, want: `"1",""` + "\n"},
+		{name: "all null", record: [][]byte{nil, nil}, want: ",\n"},
+		{name: "quote", record: [][]byte{[]byte(`a"b`)}, want: `"a""b"` + "\n"},
+		{name: "comma and newline", record: [][]byte{[]byte("a,b\nc")}, want: "\"a,b\nc\"\n"},
+		{name: "backslash", record: [][]byte{[]byte(`\N`)}, want: `"\N"` + "\n"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			writeCSVRecord(&buf, c.record)
+			if got := buf.String(); got != c.want {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestEscapeString(t *testing.T) {
+	for _, c := range []struct {
+		value string
+		want  string
+	}{
+		{value: "abc", want: "abc"},
+		{value: "a\x00b", want: `a\0b`},
+		{value: "a\nb\rc", want: `a\nb\rc`},
+		{value: `a\b`, want: `a\\b`},
+		{value: `it's "quoted"`, want: `it\'s \"quoted\"`},
+		{value: "a\x1ab", want: `a\Zb`},
+		{value: "中文", want: "中文"},
+	} {
+		var buf bytes.Buffer
+		escapeString(&buf, []byte(c.value))
+		if got := buf.String(); got != c.want {
+			t.Errorf("escapeString(%q) = %q, want %q", c.value, got, c.want)
+		}
+	}
+}
+
+func TestFormatRows(t *testing.T) {
+	batch := &RowBatch{
+		Table:   "t1",
+		Columns: []string{"id", "name", "data"},
+		Types:   []string{"UNSIGNED BIGINT", "VARCHAR", "VARBINARY"},
+		Values: [][]byte{
+			[]byte("1"), []byte(`a"b`), {0xff, 0x00},
+			[]byte("2"), nil, {},
+		},
+		Rows: 2,
+	}
+	for _, c := range []struct {
+		name   string
+		format string
+		table  string
+		header bool
+		want   string
+	}{
+		{name: "csv with header", format: FormatCSV, header: true, want: `"id","name","data"` + "\n" + `"1","a""b","` + "\xff\x00" + `"` + "\n" + `"2",,""` + "\n"},
+		{name: "csv", format: FormatCSV, want: `"1","a""b","` + "\xff\x00" + `"` + "\n" + `"2",,""` + "\n"},
+		{name: "jsonl", format: FormatJSONL, want: `{"id":1,"name":"a\"b","data":"/wA="}` + "\n" + `{"id":2,"name":null,"data":""}` + "\n"},
+		{name: "sql", format: FormatSQL, table: "t2", want: "INSERT INTO `t2` (`id`, `name`, `data`) VALUES (1, 'a\\\"b', 0xff00), (2, NULL, '');\n"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			content, err := formatRows(c.format, c.table, c.header, batch)
+			if err != nil {
+				t.Fatalf("formatRows: %v", err)
+			}
+			if got := string(content); got != c.want {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+	if _, err := formatRows("xml", "", false, batch); err == nil {
+		t.Error("formatRows succeeded with an unsupported format")
+	}
+}
+
+// failingWriter
+//  write through to the file, and fail when the commit is synced
+type failingWriter struct {
+	buf *bufio.Writer
+}
+
+func (w failingWriter) Write(p []byte) (n int, err error) {
+	if n, err = w.buf.Write(p); err != nil {
+		return
+	}
+	err = w.buf.Flush()
+	return
+}
+
+func (w failingWriter) Flush() error {
+	return w.buf.Flush()
+}
+
+func (w failingWriter) Close() error {
+	return errors.New("sync failed")
+}
+
+func TestFileSinkCommitFailure(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewFileSink(&FileSinkParam{Dir: dir, Format: FormatCSV, Compress: CompressNone, Name: "db.t1"})
+	if err != nil {
+		t.Fatalf("NewFileSink: %v", err)
+	}
+	batch := func(value string) []*RowBatch {
+		return []*RowBatch{{Table: "t1", Columns: []string{"id"}, Types: []string{"INT"}, Values: [][]byte{[]byte(value)}, Rows: 1}}
+	}
+	if err = sink.commit(batch("1")); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	name := sink.file.Name()
+	sink.writer = failingWriter{buf: sink.buf}
+	if err = sink.commit(batch("2")); err == nil {
+		t.Fatal("commit succeeded, want an error")
+	}
+	if sink.file != nil {
+		t.Error("the file is still open after a failed commit")
+	}
+	content, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	if want := `"id"` + "\n" + `"1"` + "\n"; string(content) != want {
+		t.Errorf("got %q, want %q", content, want)
+	}
+
+	// the rows after the failed commit go to a new file
+	if err = sink.commit(batch("3")); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if err = sink.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "db.t1.*.csv"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("got the files %v, want 2 of them", files)
+	}
+}
@@ -0,0 +1,150 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+)
+
+// MySQLSource
+//  a table the rows are archived from
+type MySQLSource struct {
+	DB       *sql.DB
+	Table    string
+	Where    string
+	Analysis Analysis
+}
+
+func (s *MySQLSource) Fetch(ctx context.Context, cursor [][]byte, limit int64) (*RowBatch, error) {
+	return SelectRows(ctx, &SelectParam{
+		DB:       s.DB,
+		Table:    s.Table,
+		Where:    s.Where,
+		Limit:    limit,
+		Analysis: s.Analysis,
+		Cursor:   cursor,
+	})
+}
+
+// Begin
+//  the transaction is not bound to ctx, since database/sql rolls a transaction back by itself once its context is
+//  cancelled, which must not happen between the commits of the sink and the source
+func (s *MySQLSource) Begin(ctx context.Context) (SourceTx, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &mysqlSourceTx{tx: tx, source: s}, nil
+}
+
+func (s *MySQLSource) BeginXA(ctx context.Context, gtrid string) (XASourceTx, error) {
+	xa, err := BeginXA(ctx, s.DB, gtrid, BranchSource)
+	if err != nil {
+		return nil, err
+	}
+	return &mysqlSourceXA{mysqlSourceTx{tx: xa, source: s}, xa}, nil
+}
+
+func (s *MySQLSource) Checksum(ctx context.Context, batch *RowBatch) (Checksum, error) {
+	return ChecksumBatch(ctx, s.DB, s.Table, batch)
+}
+
+type mysqlSourceTx struct {
+	tx     Tx
+	source *MySQLSource
+}
+
+func (t *mysqlSourceTx) Delete(ctx context.Context, batch *RowBatch) (int64, error) {
+	return DeleteRows(ctx, &DeleteParam{
+		Tx:       t.tx,
+		Table:    t.source.Table,
+		Where:    &batch.Key.Where,
+		Limit:    batch.Key.Limit,
+		Values:   batch.Key.Values,
+		Analysis: t.source.Analysis,
+	})
+}
+
+// Checksum
+//  taken in the transaction, before the rows are deleted
+func (t *mysqlSourceTx) Checksum(ctx context.Context, batch *RowBatch) (Checksum, error) {
+	return ChecksumBatch(ctx, t.tx, t.source.Table, batch)
+}
+
+func (t *mysqlSourceTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *mysqlSourceTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+type mysqlSourceXA struct {
+	mysqlSourceTx
+	xa *XA
+}
+
+func (t *mysqlSourceXA) Prepare() error {
+	return t.xa.Prepare()
+}
+
+// MySQLSink
+//  a table the rows are archived to
+type MySQLSink struct {
+	DB       *sql.DB
+	Table    string
+	Analysis Analysis
+}
+
+// Begin
+//  not bound to ctx either, see MySQLSource.Begin
+func (s *MySQLSink) Begin(ctx context.Context) (SinkTx, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &mysqlSinkTx{tx: tx, sink: s}, nil
+}
+
+func (s *MySQLSink) BeginXA(ctx context.Context, gtrid string) (XASinkTx, error) {
+	xa, err := BeginXA(ctx, s.DB, gtrid, BranchTarget)
+	if err != nil {
+		return nil, err
+	}
+	return &mysqlSinkXA{mysqlSinkTx{tx: xa, sink: s}, xa}, nil
+}
+
+// Close
+//  the connections are closed by whoever has opened them
+func (s *MySQLSink) Close() error {
+	return nil
+}
+
+type mysqlSinkTx struct {
+	tx   Tx
+	sink *MySQLSink
+}
+
+func (t *mysqlSinkTx) Write(ctx context.Context, batch *RowBatch) (int64, error) {
+	return InsertRows(ctx, &InsertParam{Tx: t.tx, Table: t.sink.Table, Batch: batch})
+}
+
+func (t *mysqlSinkTx) Verify(ctx context.Context, batch *RowBatch) error {
+	return VerifyRows(ctx, &VerifyParam{Tx: t.tx, Table: t.sink.Table, Batch: batch, Analysis: t.sink.Analysis})
+}
+
+func (t *mysqlSinkTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *mysqlSinkTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+type mysqlSinkXA struct {
+	mysqlSinkTx
+	xa *XA
+}
+
+func (t *mysqlSinkXA) Prepare() error {
+	return t.xa.Prepare()
+}
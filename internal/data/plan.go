@@ -0,0 +1,75 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+type TemplateParam struct {
+	DB          *sql.DB
+	Table       string
+	Where       string
+	Limit       int64
+	Analysis    Analysis
+	TargetTable string
+}
+
+// Templates
+//  the statements run by SelectRows, InsertRows and DeleteRows, a tuple followed by ", ..." is repeated once
+//  per row of the batch
+type Templates struct {
+	Columns     []string
+	FirstSelect string
+	NextSelect  string
+	Insert      string
+	FirstDelete string
+	NextDelete  string
+}
+
+// QueryTemplates
+//  render the statements of a task without running them, the columns are read with a LIMIT 0 query
+func QueryTemplates(ctx context.Context, param *TemplateParam) (templates *Templates, err error) {
+	var rows *sql.Rows
+	if rows, err = param.DB.QueryContext(ctx, fmt.Sprintf("SELECT /* go-mysql-archiver */ * FROM `%s` LIMIT 0", param.Table)); err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	templates = new(Templates)
+	if templates.Columns, err = rows.Columns(); err != nil {
+		return
+	}
+
+	analysis := param.Analysis
+	seek := seekCondition(analysis)
+	templates.FirstSelect = selectQuery(param.Table, param.Where, param.Limit, analysis)
+	templates.NextSelect = selectQuery(param.Table, joinConditions(param.Where, seek), param.Limit, analysis)
+
+	placeholders := make([]string, len(templates.Columns))
+	for i := range placeholders {
+		placeholders[i] = "?"
+	}
+	templates.Insert = insertQuery(param.TargetTable, columnList(templates.Columns), "("+strings.Join(placeholders, ", ")+"), ...")
+
+	switch analysis.QueryType {
+	case 1:
+		placeholders = placeholders[:len(analysis.Columns)]
+		where := "(`" + strings.Join(analysis.Columns, "`, `") + "`) IN ((" + strings.Join(placeholders, ", ") + "), ...)"
+		templates.FirstDelete = deleteQuery(param.Table, where, param.Limit, analysis)
+		templates.NextDelete = templates.FirstDelete
+	case 2:
+		templates.FirstDelete = deleteQuery(param.Table, param.Where, param.Limit, analysis)
+		templates.NextDelete = deleteQuery(param.Table, joinConditions(param.Where, seek), param.Limit, analysis)
+	case 3:
+		expressions := make([]string, len(templates.Columns))
+		for i, column := range templates.Columns {
+			// IS NULL takes the place of "= ?" for NULL values
+			expressions[i] = "`" + column + "` = ?"
+		}
+		where := "(" + strings.Join(expressions, " AND ") + ") OR ..."
+		templates.FirstDelete = deleteQuery(param.Table, where, param.Limit, analysis)
+		templates.NextDelete = templates.FirstDelete
+	}
+	return
+}
@@ -0,0 +1,100 @@
+package data
+
+import "context"
+
+// RowBatch
+//  the rows of a batch as the raw values of the text protocol, a value is nil for NULL
+type RowBatch struct {
+	// Table is the source table the rows come from
+	Table   string
+	Columns []string
+	// Types are the database type names of the columns, such as INT and VARCHAR
+	Types []string
+	// Values holds the rows one after another, len(Columns) values per row
+	Values [][]byte
+	Rows   int64
+	// Cursor is the key of the last row, the next batch is fetched after it, empty when the source is not paged
+	// through a key
+	Cursor [][]byte
+	// Key is how the source finds the rows again to delete them, it is recorded in the checkpoint
+	Key Key
+}
+
+type Key struct {
+	Where  string
+	Values [][]byte
+	// Limit bounds the rows deleted by Where
+	Limit int64
+}
+
+// Row
+//  the values of the i-th row
+func (b *RowBatch) Row(i int64) [][]byte {
+	colQty := int64(len(b.Columns))
+	return b.Values[i*colQty : (i+1)*colQty]
+}
+
+// RowSource
+//  where the rows are archived from, they are deleted in a transaction that is committed after the sink has
+//  committed them
+type RowSource interface {
+	// Fetch reads up to limit rows after the cursor, the cursor is empty for the first batch
+	Fetch(ctx context.Context, cursor [][]byte, limit int64) (*RowBatch, error)
+	// Begin starts the transaction the rows are deleted in, ctx is only for starting it, the transaction must not
+	// be rolled back once ctx is done
+	Begin(ctx context.Context) (SourceTx, error)
+}
+
+type SourceTx interface {
+	Delete(ctx context.Context, batch *RowBatch) (rows int64, err error)
+	Commit() error
+	Rollback() error
+}
+
+// RowSink
+//  where the rows are archived to. A sink that can not roll back, such as a file, should hold the rows written
+//  until they are committed, since the batch may still fail before then
+type RowSink interface {
+	// Begin works like RowSource.Begin
+	Begin(ctx context.Context) (SinkTx, error)
+	// Close is called once the task has finished
+	Close() error
+}
+
+type SinkTx interface {
+	Write(ctx context.Context, batch *RowBatch) (rows int64, err error)
+	Commit() error
+	Rollback() error
+}
+
+// XASource
+//  a source whose transactions can be committed with the sink ones in two phases
+type XASource interface {
+	BeginXA(ctx context.Context, gtrid string) (XASourceTx, error)
+}
+
+type XASourceTx interface {
+	SourceTx
+	Prepare() error
+}
+
+type XASink interface {
+	BeginXA(ctx context.Context, gtrid string) (XASinkTx, error)
+}
+
+type XASinkTx interface {
+	SinkTx
+	Prepare() error
+}
+
+// Checksummer
+//  implemented by the sources that can checksum the rows of a batch, and by their transactions, for reconcile
+type Checksummer interface {
+	Checksum(ctx context.Context, batch *RowBatch) (Checksum, error)
+}
+
+// Verifier
+//  implemented by the sink transactions that can read the rows written back, for verify
+type Verifier interface {
+	Verify(ctx context.Context, batch *RowBatch) error
+}
@@ -0,0 +1,101 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"strings"
+)
+
+// rowChecksum
+//  CRC32 of the values of a row, NULL and empty values are told apart
+func rowChecksum(values [][]byte) uint32 {
+	var (
+		hash   = crc32.NewIEEE()
+		prefix = make([]byte, binary.MaxVarintLen64+1)
+	)
+	for _, value := range values {
+		if value == nil {
+			_, _ = hash.Write([]byte{0})
+			continue
+		}
+		prefix[0] = 1
+		n := binary.PutUvarint(prefix[1:], uint64(len(value)))
+		_, _ = hash.Write(prefix[:n+1])
+		_, _ = hash.Write(value)
+	}
+	return hash.Sum32()
+}
+
+func rowKey(values [][]byte, positions []int) string {
+	parts := make([]string, len(positions))
+	for i, position := range positions {
+		parts[i] = fmt.Sprintf("%q", values[position])
+	}
+	return "(" + strings.Join(parts, ", ") + ")"
+}
+
+type VerifyParam struct {
+	Tx       Tx
+	Table    string
+	Batch    *RowBatch
+	Analysis Analysis
+}
+
+// VerifyRows
+//  read the rows of a batch back from the target by the unique key, and compare the CRC32 of every row with the
+//  source one, only available for QueryType 1
+func VerifyRows(ctx context.Context, param *VerifyParam) (err error) {
+	if param.Analysis.QueryType != 1 {
+		err = fmt.Errorf("rows can only be verified by a non-nullable unique key")
+		return
+	}
+	batch := param.Batch
+	colQty := len(batch.Columns)
+	checksums := make(map[string]uint32, batch.Rows)
+	for i := int64(0); i < batch.Rows; i++ {
+		row := batch.Row(i)
+		checksums[rowKey(row, param.Analysis.Positions)] = rowChecksum(row)
+	}
+
+	query := fmt.Sprintf("SELECT /* go-mysql-archiver */ %s FROM `%s` WHERE %s", columnList(batch.Columns), param.Table, batch.Key.Where)
+	var rows *sql.Rows
+	if rows, err = param.Tx.QueryContext(ctx, query, rawArgs(batch.Key.Values)...); err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+
+	dest := make([]interface{}, colQty)
+	for i := range dest {
+		dest[i] = new([]byte)
+	}
+	row := make([][]byte, colQty)
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return
+		}
+		for i := range dest {
+			row[i] = *(dest[i].(*[]byte))
+		}
+		key := rowKey(row, param.Analysis.Positions)
+		checksum, ok := checksums[key]
+		if !ok {
+			continue
+		}
+		if checksum != rowChecksum(row) {
+			err = fmt.Errorf("verify: the row with key %s differs between the source and the target", key)
+			return
+		}
+		delete(checksums, key)
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	for key := range checksums {
+		err = fmt.Errorf("verify: %d rows are missing in the target, such as the row with key %s", len(checksums), key)
+		return
+	}
+	return
+}
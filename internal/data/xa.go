@@ -0,0 +1,135 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+const (
+	BranchSource = "source"
+	BranchTarget = "target"
+)
+
+// Tx
+//  a transaction that rows are written in, either a *sql.Tx or an *XA
+type Tx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	Commit() error
+	Rollback() error
+}
+
+// XA
+//  an XA transaction branch, it holds a dedicated connection until committed or rolled back. Only the statements
+//  of the branch take a context, ending, preparing and finishing it never stop halfway
+type XA struct {
+	conn  *sql.Conn
+	xid   string
+	ended bool
+}
+
+func xidLiteral(gtrid string, bqual string) string {
+	return fmt.Sprintf("'%s', '%s'", gtrid, bqual)
+}
+
+func BeginXA(ctx context.Context, db *sql.DB, gtrid string, bqual string) (xa *XA, err error) {
+	var conn *sql.Conn
+	if conn, err = db.Conn(ctx); err != nil {
+		return
+	}
+	xid := xidLiteral(gtrid, bqual)
+	if _, err = conn.ExecContext(ctx, "XA START "+xid); err != nil {
+		_ = conn.Close()
+		return
+	}
+	xa = &XA{conn: conn, xid: xid}
+	return
+}
+
+func (x *XA) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return x.conn.ExecContext(ctx, query, args...)
+}
+
+func (x *XA) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return x.conn.QueryContext(ctx, query, args...)
+}
+
+func (x *XA) end() (err error) {
+	if x.ended {
+		return
+	}
+	if _, err = x.conn.ExecContext(context.Background(), "XA END "+x.xid); err != nil {
+		return
+	}
+	x.ended = true
+	return
+}
+
+// Prepare
+//  once prepared, the branch survives a disconnection or a crash and can only be finished by XID
+func (x *XA) Prepare() (err error) {
+	if err = x.end(); err != nil {
+		return
+	}
+	_, err = x.conn.ExecContext(context.Background(), "XA PREPARE "+x.xid)
+	return
+}
+
+func (x *XA) Commit() (err error) {
+	defer func() { _ = x.conn.Close() }()
+	_, err = x.conn.ExecContext(context.Background(), "XA COMMIT "+x.xid)
+	return
+}
+
+func (x *XA) Rollback() (err error) {
+	defer func() { _ = x.conn.Close() }()
+	if err = x.end(); err != nil {
+		return
+	}
+	_, err = x.conn.ExecContext(context.Background(), "XA ROLLBACK "+x.xid)
+	return
+}
+
+// RecoverXA
+//  list the gtrid of the prepared branches whose gtrid has the prefix and whose bqual is the given one
+func RecoverXA(ctx context.Context, db *sql.DB, prefix string, bqual string) (gtrids []string, err error) {
+	var rows *sql.Rows
+	if rows, err = db.QueryContext(ctx, "XA RECOVER"); err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+
+	var (
+		formatID    int64
+		gtridLength int
+		bqualLength int
+		xid         []byte
+	)
+	for rows.Next() {
+		if err = rows.Scan(&formatID, &gtridLength, &bqualLength, &xid); err != nil {
+			return
+		}
+		if len(xid) != gtridLength+bqualLength {
+			continue
+		}
+		gtrid, branch := string(xid[:gtridLength]), string(xid[gtridLength:])
+		if branch != bqual || !strings.HasPrefix(gtrid, prefix) {
+			continue
+		}
+		gtrids = append(gtrids, gtrid)
+	}
+	err = rows.Err()
+	return
+}
+
+func CommitXA(ctx context.Context, db *sql.DB, gtrid string, bqual string) (err error) {
+	_, err = db.ExecContext(ctx, "XA COMMIT "+xidLiteral(gtrid, bqual))
+	return
+}
+
+func RollbackXA(ctx context.Context, db *sql.DB, gtrid string, bqual string) (err error) {
+	_, err = db.ExecContext(ctx, "XA ROLLBACK "+xidLiteral(gtrid, bqual))
+	return
+}
@@ -0,0 +1,100 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// rotatingFile
+//  a log file that is renamed to file.1 once it has reached the max size, the older backups are shifted to
+//  file.2, file.3, etc, and the ones beyond the max backups are removed
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (f *rotatingFile, err error) {
+	f = &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	err = f.open()
+	return
+}
+
+func (f *rotatingFile) open() (err error) {
+	var file *os.File
+	if file, err = os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+		return
+	}
+	var info os.FileInfo
+	if info, err = file.Stat(); err != nil {
+		_ = file.Close()
+		return
+	}
+	f.file, f.size = file, info.Size()
+	return
+}
+
+func (f *rotatingFile) backup(i int) string {
+	return fmt.Sprintf("%s.%d", f.path, i)
+}
+
+// rotate
+//  the file is opened again even if renaming fails, so that logging carries on in the same file
+func (f *rotatingFile) rotate() (err error) {
+	if err = f.file.Close(); err != nil {
+		return
+	}
+	f.file = nil
+	if f.maxBackups <= 0 {
+		err = os.Remove(f.path)
+	} else {
+		err = os.Remove(f.backup(f.maxBackups))
+		for i := f.maxBackups - 1; i >= 1 && (err == nil || os.IsNotExist(err)); i-- {
+			err = os.Rename(f.backup(i), f.backup(i+1))
+		}
+		if err == nil || os.IsNotExist(err) {
+			err = os.Rename(f.path, f.backup(1))
+		}
+	}
+	if e := f.open(); e != nil {
+		return e
+	}
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
+
+func (f *rotatingFile) Write(p []byte) (n int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		if err = f.open(); err != nil {
+			return
+		}
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err = f.rotate(); err != nil && f.file == nil {
+			return
+		}
+	}
+	n, err = f.file.Write(p)
+	f.size += int64(n)
+	return
+}
+
+func (f *rotatingFile) Close() (err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return
+	}
+	err = f.file.Close()
+	f.file = nil
+	return
+}
@@ -0,0 +1,202 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const TimeFormat = "2006-01-02 15:04:05"
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return "unknown"
+	}
+	return levelNames[l]
+}
+
+func ParseLevel(name string) (level Level, err error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			level = Level(i)
+			return
+		}
+	}
+	err = fmt.Errorf("unknown log level %q, it should be one of debug, info, warn and error", name)
+	return
+}
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+type Options struct {
+	Level  Level
+	Format string
+	// File is where the lines are written, empty means stdout
+	File string
+	// MaxSize is the size in bytes after which the file is rotated, 0 means never
+	MaxSize int64
+	// MaxBackups is the number of rotated files kept, such as file.1, file.2, etc
+	MaxBackups int
+}
+
+// Logger
+//  write levelled lines with key-value fields, in text or JSON
+type Logger struct {
+	level  Level
+	format string
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func New(opts Options) (l *Logger, err error) {
+	switch opts.Format {
+	case FormatText, FormatJSON:
+	default:
+		err = fmt.Errorf("unknown log format %q, it should be one of text and json", opts.Format)
+		return
+	}
+	l = &Logger{level: opts.Level, format: opts.Format, out: os.Stdout}
+	if opts.File != "" {
+		if l.out, err = openRotatingFile(opts.File, opts.MaxSize, opts.MaxBackups); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (l *Logger) Close() (err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c, ok := l.out.(io.Closer); ok {
+		err = c.Close()
+	}
+	return
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+// formatValue
+//  render a value of the text format, it is quoted when it could be mistaken for another field
+func formatValue(value interface{}) string {
+	var s string
+	switch v := value.(type) {
+	case time.Duration:
+		s = v.String()
+	case error:
+		s = v.Error()
+	case fmt.Stringer:
+		s = v.String()
+	default:
+		s = fmt.Sprint(v)
+	}
+	if s == "" || strings.ContainsAny(s, " =\"\n\t") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Duration:
+		return v.String()
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return v
+	}
+}
+
+// Log
+//  write a line, the fields are key-value pairs, such as "rows", 500, "took", time.Second
+func (l *Logger) Log(level Level, msg string, fields ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	now := time.Now().Local()
+	if len(fields)%2 != 0 {
+		fields = append(fields, "")
+	}
+
+	var buf bytes.Buffer
+	switch l.format {
+	case FormatJSON:
+		buf.WriteString("{")
+		write := func(key string, value interface{}) {
+			if buf.Len() > 1 {
+				buf.WriteString(",")
+			}
+			k, _ := json.Marshal(key)
+			v, err := json.Marshal(jsonValue(value))
+			if err != nil {
+				v, _ = json.Marshal(fmt.Sprint(value))
+			}
+			buf.Write(k)
+			buf.WriteString(":")
+			buf.Write(v)
+		}
+		write("time", now.Format(time.RFC3339Nano))
+		write("level", level.String())
+		write("msg", msg)
+		for i := 0; i < len(fields); i += 2 {
+			write(fmt.Sprint(fields[i]), fields[i+1])
+		}
+		buf.WriteString("}\n")
+	default:
+		fmt.Fprintf(&buf, "[%s] %-5s %s", now.Format(TimeFormat), strings.ToUpper(level.String()), msg)
+		for i := 0; i < len(fields); i += 2 {
+			fmt.Fprintf(&buf, " %s=%s", fields[i], formatValue(fields[i+1]))
+		}
+		buf.WriteString("\n")
+	}
+
+	l.mu.Lock()
+	_, _ = l.out.Write(buf.Bytes())
+	l.mu.Unlock()
+}
+
+func (l *Logger) Debug(msg string, fields ...interface{}) { l.Log(LevelDebug, msg, fields...) }
+func (l *Logger) Info(msg string, fields ...interface{})  { l.Log(LevelInfo, msg, fields...) }
+func (l *Logger) Warn(msg string, fields ...interface{})  { l.Log(LevelWarn, msg, fields...) }
+func (l *Logger) Error(msg string, fields ...interface{}) { l.Log(LevelError, msg, fields...) }
+
+var std = &Logger{level: LevelInfo, format: FormatText, out: os.Stdout}
+
+// SetDefault
+//  replace the logger used by the package level functions
+func SetDefault(l *Logger) {
+	std = l
+}
+
+func Default() *Logger {
+	return std
+}
+
+func Debug(msg string, fields ...interface{}) { std.Log(LevelDebug, msg, fields...) }
+func Info(msg string, fields ...interface{})  { std.Log(LevelInfo, msg, fields...) }
+func Warn(msg string, fields ...interface{})  { std.Log(LevelWarn, msg, fields...) }
+func Error(msg string, fields ...interface{}) { std.Log(LevelError, msg, fields...) }
@@ -0,0 +1,365 @@
+// Package archiver
+//  archive the rows of a MySQL table from Go code, it runs the same task as the archiver command
+package archiver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/dbadylan/go-mysql-archiver/internal/biz"
+	"github.com/dbadylan/go-mysql-archiver/internal/config"
+	"github.com/dbadylan/go-mysql-archiver/internal/data"
+	"github.com/dbadylan/go-mysql-archiver/internal/logger"
+)
+
+type MySQL struct {
+	// Address is host:port, 127.0.0.1:3306 when empty
+	Address string
+	// Username is root when empty
+	Username string
+	Password string
+	Database string
+	// Charset is utf8mb4 when empty for the source, and the source one for the target and the replicas
+	Charset string
+}
+
+// Threshold
+//  a limit on a global status variable, such as Threads_running=50
+type Threshold struct {
+	Name  string
+	Value int64
+}
+
+// Options
+//  the options of a task, every field is the flag of the same name of the archiver command, a zero value takes
+//  the default of the flag unless told otherwise
+type Options struct {
+	Source MySQL
+	// Table is a table, or comma-separated tables archived with the same Where
+	Table string
+	// TableRegex archives the tables of the source database whose whole names match it instead, .* for every
+	// table
+	TableRegex string
+	// Concurrency is the number of tables archived at the same time, 1 when 0
+	Concurrency int
+	// Where is the condition of the rows to archive, every row when empty
+	Where string
+	// Limit is the number of rows of a batch
+	Limit int64
+
+	// Target defaults to the source database, TargetTable to the source table
+	Target      MySQL
+	TargetTable string
+	// Dir archives to local files in this directory instead of a MySQL table
+	Dir      string
+	Format   string
+	Compress string
+	FileSize int64
+	// Sink archives to a sink of its own instead of a MySQL table or local files, it can not be used along with
+	// Dir, Purge, Verify, Reconcile and XA. With several tables, the batches of every table go to the sink, and
+	// it is used from several goroutines at the same time when Concurrency is greater than 1
+	Sink RowSink
+
+	Purge          bool
+	NoDelete       bool
+	Verify         bool
+	Reconcile      bool
+	ReconcileChunk int64
+	XA             bool
+	Checkpoint     string
+	Resume         bool
+
+	Sleep   time.Duration
+	RunTime time.Duration
+	// Progress is 0 by default, which means no progress lines are logged
+	Progress         time.Duration
+	EstimateInterval time.Duration
+	EstimateCount    bool
+	Statistics       bool
+	StatisticsFile   string
+	// Socket is the path of the control socket, there is none when empty
+	Socket     string
+	HTTPListen string
+
+	// Replicas default to the credentials of the source
+	Replicas       []MySQL
+	MaxLag         time.Duration
+	HeartbeatTable string
+	CheckInterval  time.Duration
+	MaxLoad        []Threshold
+	CriticalLoad   []Threshold
+
+	// Retries is 0 by default, which means a failed batch is not retried
+	Retries       int
+	RetryInterval time.Duration
+	QueryTimeout  time.Duration
+
+	// OnBatch is called after a batch has been committed. The hooks are called from several goroutines at the same
+	// time when Concurrency is greater than 1
+	OnBatch func(batch Batch)
+	// OnError is called every time a batch has failed, including the attempts that are retried
+	OnError func(err error)
+	// OnThrottle is called when the task starts waiting for the replicas or the source load, with the reason, and
+	// with an empty reason once it carries on
+	OnThrottle func(reason string)
+}
+
+// RowBatch, RowSink and SinkTx
+//  what a sink of its own is made of, the rows of a batch are written in a transaction of the sink, which is
+//  committed before the rows are deleted from the source
+type (
+	RowBatch = data.RowBatch
+	Key      = data.Key
+	RowSink  = data.RowSink
+	SinkTx   = data.SinkTx
+)
+
+// NewWriterSink
+//  a sink that writes the rows to w, such as os.Stdout, in one of the formats csv, jsonl and sql, table is the
+//  table of the INSERT statements of the sql format, the table of each batch when empty. Shared by several tables,
+//  the csv header is written again whenever the table changes
+func NewWriterSink(w io.Writer, format string, table string) (RowSink, error) {
+	sink, err := data.NewWriterSink(w, format, table)
+	if err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+// Batch
+//  a committed batch
+type Batch struct {
+	Table string
+	// Number counts the batches of the table
+	Number   int64
+	Rows     int64
+	Inserted int64
+	Deleted  int64
+	// Cursor is the key of the last row of the batch, empty when the table is not paged through a key
+	Cursor string
+	// Took includes the retries of the batch
+	Took time.Duration
+}
+
+// TableStats
+//  what a task has done so far to one of its tables
+type TableStats struct {
+	Table string
+	// State is one of pending, running, done, stopped and failed
+	State     string
+	Batches   int64
+	Selected  int64
+	Inserted  int64
+	Deleted   int64
+	Retries   int64
+	Errors    int64
+	Estimated int64
+	Cursor    string
+	// Err is what the table has failed with
+	Err error
+}
+
+// Stats
+//  what a task has done so far, the counters add up every table
+type Stats struct {
+	// State is one of pending, running, paused, throttled, stopping and finished
+	State     string
+	Batches   int64
+	Selected  int64
+	Inserted  int64
+	Deleted   int64
+	Retries   int64
+	Errors    int64
+	Estimated int64
+	// Cursor is empty when there are several tables
+	Cursor string
+	Begin  time.Time
+	// Finish is zero until the task has finished
+	Finish time.Time
+	// Err is what Run has returned
+	Err error
+	// Tables are empty until the tables have been analyzed
+	Tables []TableStats
+}
+
+// Archiver
+//  a task, it can be paused, resumed and stopped from other goroutines while Run is running, and it can only be
+//  run once
+type Archiver struct {
+	job *biz.Job
+}
+
+func withDefault(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func mysqlConfig(m MySQL, base MySQL) config.MySQL {
+	return config.MySQL{
+		Address:  withDefault(m.Address, base.Address),
+		Username: withDefault(m.Username, base.Username),
+		Password: withDefault(m.Password, base.Password),
+		Database: m.Database,
+		Charset:  withDefault(m.Charset, base.Charset),
+	}
+}
+
+func thresholds(list []Threshold) []config.Threshold {
+	converted := make([]config.Threshold, len(list))
+	for i, threshold := range list {
+		converted[i] = config.Threshold{Name: threshold.Name, Value: threshold.Value}
+	}
+	return converted
+}
+
+func durationDefault(value time.Duration, fallback time.Duration) time.Duration {
+	if value == 0 {
+		return fallback
+	}
+	return value
+}
+
+// New
+//  check the options, nothing is connected to until Run
+func New(opts Options) (a *Archiver, err error) {
+	defaults := MySQL{Address: "127.0.0.1:3306", Username: "root", Charset: "utf8mb4"}
+	source := mysqlConfig(opts.Source, defaults)
+	cfg := &config.Config{
+		Source: config.Source{
+			MySQL:      source,
+			Table:      opts.Table,
+			TableRegex: opts.TableRegex,
+			Where:      opts.Where,
+			Limit:      opts.Limit,
+		},
+		Target: config.Target{
+			MySQL:    mysqlConfig(opts.Target, MySQL{Address: defaults.Address, Username: defaults.Username}),
+			Table:    opts.TargetTable,
+			Dir:      opts.Dir,
+			Format:   withDefault(opts.Format, "csv"),
+			Compress: withDefault(opts.Compress, "none"),
+			FileSize: opts.FileSize,
+		},
+		Progress:   opts.Progress,
+		Sleep:      opts.Sleep,
+		Statistics: opts.Statistics,
+		RunTime:    opts.RunTime,
+		Socket:     opts.Socket,
+		HTTPListen: opts.HTTPListen,
+		Checkpoint: opts.Checkpoint,
+		Resume:     opts.Resume,
+		XA:         opts.XA,
+		Purge:      opts.Purge,
+		NoDelete:   opts.NoDelete,
+		Verify:     opts.Verify,
+
+		Reconcile:      opts.Reconcile,
+		ReconcileChunk: opts.ReconcileChunk,
+
+		MaxLag:         durationDefault(opts.MaxLag, time.Second),
+		HeartbeatTable: opts.HeartbeatTable,
+		CheckInterval:  durationDefault(opts.CheckInterval, time.Second),
+		MaxLoad:        thresholds(opts.MaxLoad),
+		CriticalLoad:   thresholds(opts.CriticalLoad),
+
+		Retries:       opts.Retries,
+		RetryInterval: durationDefault(opts.RetryInterval, time.Second),
+		QueryTimeout:  opts.QueryTimeout,
+
+		EstimateInterval: opts.EstimateInterval,
+		EstimateCount:    opts.EstimateCount,
+
+		StatisticsFile: opts.StatisticsFile,
+		Log:            logger.Options{Level: logger.LevelInfo, Format: logger.FormatText},
+		Concurrency:    opts.Concurrency,
+	}
+	if opts.Sink != nil {
+		switch {
+		case opts.Dir != "":
+			err = errors.New("sink: a sink can not be used along with dir")
+		case opts.Purge:
+			err = errors.New("sink: there is no target when purging")
+		case opts.Verify, opts.Reconcile:
+			err = errors.New("sink: rows can only be verified and reconciled in a MySQL target")
+		case opts.XA:
+			err = errors.New("sink: XA transactions are only available between MySQL tables")
+		}
+		if err != nil {
+			return
+		}
+		// the target is only the name of the sink in the checkpoint and the statistics
+		cfg.Target.Address = ""
+	}
+	if cfg.ReconcileChunk == 0 {
+		cfg.ReconcileChunk = 10000
+	}
+	for _, replica := range opts.Replicas {
+		base := MySQL{Username: source.Username, Password: source.Password, Charset: source.Charset}
+		if replica.Username != "" {
+			// the password of the source is not given to another user
+			base.Password = ""
+		}
+		cfg.Replicas = append(cfg.Replicas, mysqlConfig(replica, base))
+	}
+	if err = cfg.Validate(); err != nil {
+		return
+	}
+
+	hooks := biz.Hooks{OnError: opts.OnError, OnThrottle: opts.OnThrottle}
+	if opts.OnBatch != nil {
+		hooks.OnBatch = func(batch biz.Batch) {
+			opts.OnBatch(Batch(batch))
+		}
+	}
+	a = &Archiver{job: biz.NewJob(cfg, hooks, opts.Sink)}
+	return
+}
+
+// Run
+//  run the task until it has finished, stopped or failed. Once ctx is done, the statements in flight are cancelled,
+//  the batch is rolled back and the error of ctx is returned
+func (a *Archiver) Run(ctx context.Context) error {
+	return a.job.Run(ctx)
+}
+
+// Pause
+//  hold the task after the batch in flight until Resume
+func (a *Archiver) Pause() {
+	a.job.Pause()
+}
+
+func (a *Archiver) Resume() {
+	a.job.Resume()
+}
+
+// Stop
+//  finish the task after the batch in flight, Run returns nil
+func (a *Archiver) Stop() {
+	a.job.Stop()
+}
+
+func (a *Archiver) Stats() Stats {
+	s := a.job.Stats()
+	stats := Stats{
+		State:     s.State,
+		Batches:   s.Batches,
+		Selected:  s.Selected,
+		Inserted:  s.Inserted,
+		Deleted:   s.Deleted,
+		Retries:   s.Retries,
+		Errors:    s.Errors,
+		Estimated: s.Estimated,
+		Cursor:    s.Cursor,
+		Begin:     s.Begin,
+		Finish:    s.Finish,
+		Err:       s.Err,
+	}
+	for _, table := range s.Tables {
+		stats.Tables = append(stats.Tables, TableStats(table))
+	}
+	return stats
+}